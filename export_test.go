@@ -0,0 +1,54 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog
+
+import (
+	"time"
+)
+
+// LogName returns the name of a log file for tag created at t.
+func LogName(tag string, t time.Time) string {
+	name, _ := logName(tag, t)
+	return name
+}
+
+// WaitForCompression waits until all rotated log files have been compressed.
+func WaitForCompression() {
+	logFiles.compressing.Wait()
+}
+
+// ResetCallSites forgets the state of rate limited and sampled call sites.
+func ResetCallSites() {
+	rateLimits.mu.Lock()
+	rateLimits.sites = nil
+	rateLimits.mu.Unlock()
+	samples.mu.Lock()
+	samples.calls = nil
+	samples.mu.Unlock()
+}
+
+// SetFatalHookTimeout sets how long the OnFatal hooks may take. It returns
+// a function that restores the timeout and forgets the hooks.
+func SetFatalHookTimeout(timeout time.Duration) func() {
+	fatal.mu.Lock()
+	defer fatal.mu.Unlock()
+	old := fatalHookTimeout
+	fatalHookTimeout = timeout
+	return func() {
+		fatal.mu.Lock()
+		defer fatal.mu.Unlock()
+		fatalHookTimeout, fatal.hooks = old, nil
+	}
+}
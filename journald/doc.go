@@ -0,0 +1,39 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package journald sends the lines of k8s.io/klog to the systemd journal
+// with its native protocol, as structured entries with the fields
+//
+//	MESSAGE            the message
+//	PRIORITY           6 for INFO, 4 for WARNING, 3 for ERROR and 2 for FATAL
+//	CODE_FILE          the file of the call site
+//	CODE_LINE          its line
+//	CODE_FUNC          the calling function
+//	SYSLOG_IDENTIFIER  the name of the program
+//	ERROR              the error of ErrorS or a klogr logger, if any
+//	LOGGER             the name of the klogr logger, if any
+//
+// followed by the key/value pairs of InfoS, ErrorS and klogr, with the keys
+// converted to journal field names, as in POD_NAME for "podName". Keys that
+// would become one of the fields above or another field that the journal
+// gives a meaning, such as MESSAGE_ID or SYSLOG_PID, get the prefix KLOG_,
+// as in KLOG_MESSAGE for "message". A Sink is added to klog with
+// klog.AddSink:
+//
+//	s, err := journald.Dial("")
+//	...
+//	klog.AddSink(s)
+//
+// The package is only implemented on Linux.
+package journald
@@ -0,0 +1,223 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journald
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unicode"
+
+	"k8s.io/klog"
+)
+
+// SocketPath is the path of the native protocol socket of journald.
+const SocketPath = "/run/systemd/journal/socket"
+
+// priorities maps the klog severities to syslog priorities.
+var priorities = map[string]string{"INFO": "6", "WARNING": "4", "ERROR": "3", "FATAL": "2"}
+
+// reserved are the fields that this package writes and the other fields
+// that the journal gives a meaning, which keys are not mapped to.
+var reserved = map[string]bool{
+	"MESSAGE": true, "PRIORITY": true, "CODE_FILE": true, "CODE_LINE": true, "CODE_FUNC": true,
+	"SYSLOG_IDENTIFIER": true, "ERROR": true, "LOGGER": true,
+	"MESSAGE_ID": true, "ERRNO": true, "INVOCATION_ID": true, "USER_INVOCATION_ID": true,
+	"SYSLOG_FACILITY": true, "SYSLOG_PID": true, "SYSLOG_TIMESTAMP": true, "SYSLOG_RAW": true,
+	"DOCUMENTATION": true, "TID": true, "UNIT": true, "USER_UNIT": true,
+}
+
+// reservedPrefix is added to the field names of keys that would be reserved
+// fields otherwise.
+const reservedPrefix = "KLOG_"
+
+// A Sink sends the lines it is passed to the journal.
+type Sink struct {
+	conn       *net.UnixConn
+	addr       *net.UnixAddr
+	identifier string
+}
+
+// Dial returns a Sink that sends to the journal socket at path, or at
+// SocketPath if path is empty.
+func Dial(path string) (*Sink, error) {
+	if path == "" {
+		path = SocketPath
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	s := &Sink{
+		addr:       &net.UnixAddr{Name: path, Net: "unixgram"},
+		identifier: filepath.Base(os.Args[0]),
+	}
+	// The socket is not connected, so that it can pass files and keeps
+	// working when journald is restarted. An empty name binds it to an
+	// automatic abstract address.
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: "", Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	s.conn = conn
+	return s, nil
+}
+
+// Log sends an entry to the journal, which makes a Sink a klog.Sink. Errors
+// are ignored, as the line is also written to the outputs.
+func (s *Sink) Log(e *klog.Entry) {
+	s.Send(e) // ignore error
+}
+
+// Send sends an entry to the journal.
+func (s *Sink) Send(e *klog.Entry) error {
+	data := s.encode(e)
+	_, _, err := s.conn.WriteMsgUnix(data, nil, s.addr)
+	if isTooLarge(err) {
+		// Entries that do not fit into a datagram are passed in a file.
+		return s.sendFile(data)
+	}
+	return err
+}
+
+// Close closes the socket.
+func (s *Sink) Close() error {
+	return s.conn.Close()
+}
+
+// encode returns the fields of e in the native protocol.
+func (s *Sink) encode(e *klog.Entry) []byte {
+	var b bytes.Buffer
+	msg := e.Message
+	if !e.Structured {
+		msg = e.Text()
+	}
+	writeField(&b, "MESSAGE", strings.TrimSuffix(msg, "\n"))
+	if priority, ok := priorities[e.Severity]; ok {
+		writeField(&b, "PRIORITY", priority)
+	}
+	writeField(&b, "CODE_FILE", e.File)
+	writeField(&b, "CODE_LINE", strconv.Itoa(e.Line))
+	if e.Function != "" {
+		writeField(&b, "CODE_FUNC", e.Function)
+	}
+	writeField(&b, "SYSLOG_IDENTIFIER", s.identifier)
+	if e.Err != nil {
+		writeField(&b, "ERROR", e.Err.Error())
+	}
+	if e.Logger != "" {
+		writeField(&b, "LOGGER", e.Logger)
+	}
+	for i := 0; i < len(e.KeysAndValues); i += 2 {
+		var v interface{} = "(MISSING)"
+		if i+1 < len(e.KeysAndValues) {
+			v = e.KeysAndValues[i+1]
+		}
+		name := FieldName(fmt.Sprint(e.KeysAndValues[i]))
+		if reserved[name] {
+			name = reservedPrefix + name
+		}
+		if name != "" {
+			writeField(&b, name, fmt.Sprint(v))
+		}
+	}
+	return b.Bytes()
+}
+
+// writeField writes a field, with the binary encoding for values that span
+// lines.
+func writeField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if !strings.ContainsRune(value, '\n') {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+// FieldName converts a key to a journal field name: upper case letters,
+// digits and underscores, not starting with an underscore or a digit, with
+// an underscore between words of keys in camel case. It returns "" for keys
+// without letters or digits.
+func FieldName(key string) string {
+	var b strings.Builder
+	var prev rune
+	for _, r := range key {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		case r >= 'a' && r <= 'z':
+			b.WriteRune(unicode.ToUpper(r))
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		default:
+			if b.Len() > 0 && prev != '_' {
+				b.WriteByte('_')
+			}
+			r = '_'
+		}
+		prev = r
+	}
+	name := strings.TrimLeft(strings.TrimRight(b.String(), "_"), "_0123456789")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// isTooLarge reports whether err means that a datagram was too large.
+func isTooLarge(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		if sysErr, ok := opErr.Err.(*os.SyscallError); ok {
+			return sysErr.Err == syscall.EMSGSIZE || sysErr.Err == syscall.ENOBUFS
+		}
+	}
+	return false
+}
+
+// sendFile passes data in an unlinked file, as the native protocol
+// provides for large entries.
+func (s *Sink) sendFile(data []byte) error {
+	f, err := ioutil.TempFile("/dev/shm", "klog-journal-")
+	if err != nil {
+		f, err = ioutil.TempFile("", "klog-journal-")
+		if err != nil {
+			return err
+		}
+	}
+	defer f.Close()
+	os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	_, _, err = s.conn.WriteMsgUnix(nil, syscall.UnixRights(int(f.Fd())), s.addr)
+	return err
+}
+
+var _ klog.Sink = &Sink{}
@@ -0,0 +1,257 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journald
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"k8s.io/klog"
+	"k8s.io/klog/klogr"
+)
+
+// journal is a stand-in for the journald socket.
+type journal struct {
+	t    *testing.T
+	dir  string
+	conn *net.UnixConn
+}
+
+func newJournal(t *testing.T) *journal {
+	dir, err := ioutil.TempDir("", "journald")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: filepath.Join(dir, "socket"), Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return &journal{t: t, dir: dir, conn: conn}
+}
+
+func (j *journal) path() string {
+	return filepath.Join(j.dir, "socket")
+}
+
+func (j *journal) close() {
+	j.conn.Close()
+	os.RemoveAll(j.dir)
+}
+
+// receive returns the fields of the next entry, which may be passed in a
+// file.
+func (j *journal) receive() map[string]string {
+	buf, oob := make([]byte, 256*1024), make([]byte, 1024)
+	j.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	n, oobn, _, _, err := j.conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		j.t.Fatalf("unexpected error: %v", err)
+	}
+	data := buf[:n]
+	if oobn > 0 {
+		msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			j.t.Fatalf("unexpected error: %v", err)
+		}
+		fds, err := syscall.ParseUnixRights(&msgs[0])
+		if err != nil {
+			j.t.Fatalf("unexpected error: %v", err)
+		}
+		f := os.NewFile(uintptr(fds[0]), "entry")
+		defer f.Close()
+		f.Seek(0, 0)
+		if data, err = ioutil.ReadAll(f); err != nil {
+			j.t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	fields, err := decode(data)
+	if err != nil {
+		j.t.Fatalf("%v in %q", err, data)
+	}
+	return fields
+}
+
+// decode decodes the fields of an entry in the native protocol.
+func decode(data []byte) (map[string]string, error) {
+	fields := make(map[string]string)
+	for len(data) > 0 {
+		nl := bytes.IndexByte(data, '\n')
+		if nl < 0 {
+			return nil, errors.New("missing newline")
+		}
+		if eq := bytes.IndexByte(data[:nl], '='); eq >= 0 {
+			fields[string(data[:eq])] = string(data[eq+1 : nl])
+			data = data[nl+1:]
+			continue
+		}
+		name := string(data[:nl])
+		data = data[nl+1:]
+		if len(data) < 8 {
+			return nil, errors.New("missing length")
+		}
+		n := binary.LittleEndian.Uint64(data)
+		data = data[8:]
+		if uint64(len(data)) < n+1 || data[n] != '\n' {
+			return nil, errors.New("bad binary field")
+		}
+		fields[name] = string(data[:n])
+		data = data[n+1:]
+	}
+	return fields, nil
+}
+
+func TestSink(t *testing.T) {
+	j := newJournal(t)
+	defer j.close()
+	s, err := Dial(j.path())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	flagset := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(flagset)
+	flagset.Set("logtostderr", "false")
+	flagset.Set("stderrthreshold", "FATAL")
+	defer flagset.Set("logtostderr", "true")
+	defer flagset.Set("stderrthreshold", "ERROR")
+	klog.SetOutput(ioutil.Discard)
+	defer klog.SetOutput(nil)
+	klog.AddSink(s)
+	defer klog.RemoveSink(s)
+	klogr.SetKeyDenylist("token")
+	defer klogr.SetKeyDenylist()
+
+	klog.ErrorS(errors.New("timeout"), "Sync failed", "podName", "kubedns", "trace", "a\nb")
+	_, _, line, _ := runtime.Caller(0)
+	klog.Warning("plain")
+	klogr.New().WithName("sync").WithValues("podName", "kubedns").Info("Synced", "token", "secret")
+	_, _, klogrLine, _ := runtime.Caller(0)
+
+	expected := map[string]string{
+		"MESSAGE":           "Sync failed",
+		"PRIORITY":          "3",
+		"CODE_FILE":         "journald_linux_test.go",
+		"CODE_LINE":         strconv.Itoa(line - 1),
+		"CODE_FUNC":         "k8s.io/klog/journald.TestSink",
+		"SYSLOG_IDENTIFIER": filepath.Base(os.Args[0]),
+		"ERROR":             "timeout",
+		"POD_NAME":          "kubedns",
+		"TRACE":             "a\nb",
+	}
+	if got := j.receive(); !equal(got, expected) {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+	if got := j.receive(); got["MESSAGE"] != "plain" || got["PRIORITY"] != "4" {
+		t.Errorf("unexpected fields %q", got)
+	}
+	got := j.receive()
+	if got["LOGGER"] != "sync" || got["POD_NAME"] != "kubedns" || got["TOKEN"] != klog.Redacted ||
+		got["CODE_LINE"] != strconv.Itoa(klogrLine-1) || !strings.Contains(got["MESSAGE"], `"msg"="Synced"`) {
+		t.Errorf("unexpected fields %q", got)
+	}
+}
+
+func TestLargeEntry(t *testing.T) {
+	j := newJournal(t)
+	defer j.close()
+	s, err := Dial(j.path())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	msg := strings.Repeat("x", 4*1024*1024)
+	if err := s.Send(&klog.Entry{Severity: "INFO", Message: msg}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := j.receive(); got["MESSAGE"] != msg {
+		t.Errorf("got a message of %d bytes, expected %d", len(got["MESSAGE"]), len(msg))
+	}
+}
+
+func TestReservedFields(t *testing.T) {
+	j := newJournal(t)
+	defer j.close()
+	s, err := Dial(j.path())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	err = s.Send(&klog.Entry{Severity: "INFO", File: "main.go", Line: 1, Message: "Synced", Structured: true,
+		KeysAndValues: []interface{}{"message", "m", "priority", 0, "syslogIdentifier", "evil", "messageID", "id", "pod", "kubedns"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]string{
+		"MESSAGE":                "Synced",
+		"PRIORITY":               "6",
+		"CODE_FILE":              "main.go",
+		"CODE_LINE":              "1",
+		"SYSLOG_IDENTIFIER":      filepath.Base(os.Args[0]),
+		"KLOG_MESSAGE":           "m",
+		"KLOG_PRIORITY":          "0",
+		"KLOG_SYSLOG_IDENTIFIER": "evil",
+		"KLOG_MESSAGE_ID":        "id",
+		"POD":                    "kubedns",
+	}
+	if got := j.receive(); !equal(got, expected) {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
+
+func TestFieldName(t *testing.T) {
+	for key, expected := range map[string]string{
+		"pod":         "POD",
+		"podName":     "POD_NAME",
+		"api-key":     "API_KEY",
+		"_private":    "PRIVATE",
+		"2fa":         "FA",
+		"node.ip/v4":  "NODE_IP_V4",
+		"HTTPStatus":  "HTTPSTATUS",
+		"-":           "",
+		"retry2Count": "RETRY2_COUNT",
+	} {
+		if got := FieldName(key); got != expected {
+			t.Errorf("FieldName(%q) = %q, expected %q", key, got, expected)
+		}
+	}
+}
+
+// equal reports whether two sets of fields are equal.
+func equal(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
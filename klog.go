@@ -49,6 +49,31 @@
 //	-log_dir=""
 //		Log files will be written to this directory instead of the
 //		default temporary directory.
+//	-log_rotation_interval=0
+//		If non-zero, log files are also rotated on interval boundaries,
+//		such as every full hour for 1h, and not only when they are full.
+//	-log_max_files=0, -log_max_total_size=0, -log_max_age=0
+//		If non-zero, old log files in the log directory are removed when
+//		a new log file is created so that there are at most that many per
+//		severity, at most that many megabytes in total, and none that were
+//		last written to longer ago than that.
+//	-log_compression=""
+//		If set to gzip, log files are compressed after they are rotated.
+//	-log_max_line_size=0
+//		If non-zero, longer messages are truncated to that many bytes.
+//		Per-severity limits may follow, as in 65536,FATAL=0.
+//	-log_format="glog"
+//		The format of log lines: glog for the header shown above, logfmt
+//		for ts=... level=info caller=file.go:12 msg="..." lines, or json.
+//	-log_timestamp_format="glog", -log_timestamp_utc=false
+//		The layout of the time stamp in glog headers: glog for the one
+//		shown above, year to add the year, or rfc3339nano. Time stamps,
+//		including those of log file names, are in UTC if the second flag
+//		is set and in local time otherwise.
+//	-log_multiline="", -log_fatal_multiline=""
+//		If set to escape, newlines in messages are written as \n; if set
+//		to indent, continuation lines start with " | ". The second flag
+//		applies to Fatal and Exit messages and to stack traces.
 //
 //	Other flags provide aids to debugging.
 //
@@ -71,6 +96,7 @@
 package klog
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -79,10 +105,43 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	klogv2 "k8s.io/klog/v2"
 )
 
+// severity identifies the sort of log: info, warning etc. The values match
+// the ones used by klog v2.
+type severity int32
+
+// These constants identify the log levels in order of increasing severity.
+// A message written to a high-severity log file is also written to each
+// lower-severity log file.
+const (
+	infoLog severity = iota
+	warningLog
+	errorLog
+	fatalLog
+	numSeverity = 4
+)
+
+var severityName = []string{
+	infoLog:    "INFO",
+	warningLog: "WARNING",
+	errorLog:   "ERROR",
+	fatalLog:   "FATAL",
+}
+
+func severityByName(s string) (severity, bool) {
+	s = strings.ToUpper(s)
+	for i, name := range severityName {
+		if name == s {
+			return severity(i), true
+		}
+	}
+	return 0, false
+}
+
 // OutputStats tracks the number of output lines and bytes written.
 type OutputStats = klogv2.OutputStats
 
@@ -160,6 +219,20 @@ func isLiteral(pattern string) bool {
 	return !strings.ContainsAny(pattern, `\*?[]`)
 }
 
+// v2flags is a private set of klog v2's flags. The flags are bound to the
+// same variables as the ones registered by InitFlags, which gives the shim
+// access to settings that klog v2 does not otherwise export.
+var v2flags = flag.NewFlagSet("klog/v2", flag.ContinueOnError)
+
+func init() {
+	klogv2.InitFlags(v2flags)
+}
+
+// v2flag returns the current value of the named klog v2 flag.
+func v2flag(name string) string {
+	return v2flags.Lookup(name).Value.String()
+}
+
 // InitFlags is for explicitly initializing the flags.
 func InitFlags(flagset *flag.FlagSet) {
 	if flagset == nil {
@@ -170,21 +243,123 @@ func InitFlags(flagset *flag.FlagSet) {
 
 	vmoduleFlag := flagset.Lookup("vmodule")
 	vmoduleFlag.Value = &vmoduleValue{inner: vmoduleFlag.Value}
+
+	flagset.Var(durationValue{SetRotationInterval, rotationInterval}, "log_rotation_interval",
+		"If non-zero, log files are also rotated when an interval boundary is crossed, "+
+			"for example every hour at the full hour for 1h. Log files are still rotated by size.")
+	flagset.Var(retentionValue{
+		func(p RetentionPolicy) string { return strconv.Itoa(p.MaxFiles) },
+		func(p *RetentionPolicy, value string) (err error) {
+			p.MaxFiles, err = strconv.Atoi(value)
+			return err
+		},
+	}, "log_max_files", "If non-zero, defines the maximum number of log files per severity kept in the log directory.")
+	flagset.Var(retentionValue{
+		func(p RetentionPolicy) string { return strconv.FormatUint(p.MaxTotalBytes/(1024*1024), 10) },
+		func(p *RetentionPolicy, value string) error {
+			mb, err := strconv.ParseUint(value, 10, 64)
+			p.MaxTotalBytes = mb * 1024 * 1024
+			return err
+		},
+	}, "log_max_total_size", "If non-zero, defines the maximum total size of the log files kept in the log directory. "+
+		"Unit is megabytes.")
+	flagset.Var(retentionValue{
+		func(p RetentionPolicy) string { return p.MaxAge.String() },
+		func(p *RetentionPolicy, value string) (err error) {
+			p.MaxAge, err = time.ParseDuration(value)
+			return err
+		},
+	}, "log_max_age", "If non-zero, log files that have not been written to for this long are removed from the log directory.")
+	flagset.Var(compressionValue{}, "log_compression", "If set to gzip, log files are compressed after they are rotated.")
+	flagset.Var(maxLineSizeValue{}, "log_max_line_size", "If non-zero, messages longer than this many bytes are truncated. "+
+		"Limits for single severities may follow, as in 65536,FATAL=0.")
+	flagset.Var(formatValue{}, "log_format", "The format of log lines, glog, logfmt or json.")
+	flagset.Var(timestampFormatValue{}, "log_timestamp_format", "The layout of time stamps in glog headers, glog, year or rfc3339nano.")
+	flagset.Var(timestampUTCValue{}, "log_timestamp_utc", "If true, time stamps and log file names use UTC instead of local time.")
+	flagset.Var(multilineValue{SetMultilineMode, infoLog}, "log_multiline",
+		"If set to escape, newlines in messages are written as \\n; if set to indent, continuation lines are indented with a marker.")
+	flagset.Var(multilineValue{SetFatalMultilineMode, fatalLog}, "log_fatal_multiline",
+		"Like -log_multiline, for the messages of Fatal and Exit and for stack traces.")
+}
+
+// durationValue adapts a setter and a getter of a time.Duration setting to
+// the flag.Value interface.
+type durationValue struct {
+	set func(time.Duration)
+	get func() time.Duration
+}
+
+func (d durationValue) String() string {
+	if d.get == nil {
+		return "0s"
+	}
+	return d.get().String()
+}
+
+func (d durationValue) Set(value string) error {
+	v, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	d.set(v)
+	return nil
+}
+
+// retentionValue adapts one field of the retention policy to the flag.Value
+// interface.
+type retentionValue struct {
+	get func(RetentionPolicy) string
+	set func(*RetentionPolicy, string) error
+}
+
+func (r retentionValue) String() string {
+	if r.get == nil {
+		return ""
+	}
+	return r.get(retentionPolicy())
+}
+
+func (r retentionValue) Set(value string) error {
+	p := retentionPolicy()
+	if err := r.set(&p, value); err != nil {
+		return err
+	}
+	SetRetentionPolicy(p)
+	return nil
+}
+
+// compressionValue adapts SetCompression to the flag.Value interface.
+type compressionValue struct{}
+
+func (compressionValue) String() string {
+	return string(compression())
+}
+
+func (compressionValue) Set(value string) error {
+	return SetCompression(Compression(value))
 }
 
 // Flush flushes all pending log I/O.
 func Flush() {
 	klogv2.Flush()
+	dedupe.flush()
+	async.flush()
 }
 
 // SetOutput sets the output destination for all severities
 func SetOutput(w io.Writer) {
-	klogv2.SetOutput(w)
+	for s := fatalLog; s >= infoLog; s-- {
+		setOutput(s, w)
+	}
 }
 
 // SetOutputBySeverity sets the output destination for specific severity
 func SetOutputBySeverity(name string, w io.Writer) {
-	klogv2.SetOutputBySeverity(name, w)
+	s, ok := severityByName(name)
+	if !ok {
+		panic(fmt.Sprintf("SetOutputBySeverity(%q): unrecognized severity name", name))
+	}
+	setOutput(s, w)
 }
 
 // CalculateMaxSize returns the real max size in bytes after considering the default max size and the flag options.
@@ -223,16 +398,29 @@ type Verbose bool
 // call, the V call will log.
 func V(level Level) Verbose {
 	if klogv2.V(level).Enabled() {
+		ExtendedStats.countV(level, true, false)
 		return Verbose(true)
 	}
 
 	var pcs [1]uintptr
 	if runtime.Callers(2, pcs[:]) == 0 {
+		ExtendedStats.countV(level, false, false)
 		return Verbose(false)
 	}
 
 	verbosity := vmoduleGet(pcs[0])
-	return Verbose(verbosity >= level)
+	enabled := verbosity >= level
+	ExtendedStats.countV(level, enabled, enabled)
+	if !enabled {
+		ring.noteSuppressed(1, level)
+	}
+	return Verbose(enabled)
+}
+
+// Verbosity returns the current V logging level, the value of the -v flag.
+func Verbosity() Level {
+	v, _ := strconv.ParseInt(v2flag("v"), 10, 32)
+	return Level(v)
 }
 
 // vmoduleGet takes the given program-counter and returns the loglevel
@@ -244,6 +432,9 @@ func vmoduleGet(pc uintptr) Level {
 	if v, ok := global.vModuleCache[pc]; ok {
 		return v
 	}
+	if global.vModuleCache == nil {
+		global.vModuleCache = make(map[uintptr]Level)
+	}
 
 	fn := runtime.FuncForPC(pc)
 	file, _ := fn.FileLine(pc)
@@ -268,7 +459,10 @@ func vmoduleGet(pc uintptr) Level {
 // See the documentation of V for usage.
 func (v Verbose) Info(args ...interface{}) {
 	if v {
-		klogv2.InfoDepth(1, fmt.Sprint(args...))
+		ExtendedStats.countVLine()
+		printDepth(infoLog, 1, fmt.Sprint(args...))
+	} else if ring.recordingSuppressed() {
+		ring.addSuppressed(1, fmt.Sprint(args...))
 	}
 }
 
@@ -276,7 +470,10 @@ func (v Verbose) Info(args ...interface{}) {
 // See the documentation of V for usage.
 func (v Verbose) Infoln(args ...interface{}) {
 	if v {
-		klogv2.InfoDepth(1, fmt.Sprintln(args...))
+		ExtendedStats.countVLine()
+		printDepth(infoLog, 1, fmt.Sprintln(args...))
+	} else if ring.recordingSuppressed() {
+		ring.addSuppressed(1, fmt.Sprintln(args...))
 	}
 }
 
@@ -284,107 +481,147 @@ func (v Verbose) Infoln(args ...interface{}) {
 // See the documentation of V for usage.
 func (v Verbose) Infof(format string, args ...interface{}) {
 	if v {
-		klogv2.InfoDepth(1, fmt.Sprintf(format, args...))
+		ExtendedStats.countVLine()
+		printDepth(infoLog, 1, fmt.Sprintf(format, args...))
+	} else if ring.recordingSuppressed() {
+		ring.addSuppressed(1, fmt.Sprintf(format, args...))
 	}
 }
 
 // Info logs to the INFO log.
 // Arguments are handled in the manner of fmt.Print; a newline is appended if missing.
 func Info(args ...interface{}) {
-	klogv2.InfoDepth(1, fmt.Sprint(args...))
+	printDepth(infoLog, 1, fmt.Sprint(args...))
 }
 
 // InfoDepth acts as Info but uses depth to determine which call frame to log.
 // InfoDepth(0, "msg") is the same as Info("msg").
 func InfoDepth(depth int, args ...interface{}) {
-	klogv2.InfoDepth(depth+1, fmt.Sprint(args...))
+	printDepth(infoLog, depth+1, fmt.Sprint(args...))
 }
 
 // Infoln logs to the INFO log.
 // Arguments are handled in the manner of fmt.Println; a newline is always appended.
 func Infoln(args ...interface{}) {
-	klogv2.InfoDepth(1, fmt.Sprintln(args...))
+	printDepth(infoLog, 1, fmt.Sprintln(args...))
 }
 
 // Infof logs to the INFO log.
 // Arguments are handled in the manner of fmt.Printf; a newline is appended if missing.
 func Infof(format string, args ...interface{}) {
-	klogv2.InfoDepth(1, fmt.Sprintf(format, args...))
+	printDepth(infoLog, 1, fmt.Sprintf(format, args...))
+}
+
+// InfoS structured logs to the INFO log.
+// The msg argument used to add constant description to the log line.
+// The key/value pairs would be join by "=" ; a newline is always appended.
+//
+// Basic examples:
+// >> klog.InfoS("Pod status updated", "pod", "kubedns", "status", "ready")
+// output:
+// >> I1025 00:15:15.525108       1 controller_utils.go:116] "Pod status updated" pod="kubedns" status="ready"
+func InfoS(msg string, keysAndValues ...interface{}) {
+	logDepth(infoLog, 1, structuredEntry(nil, msg, keysAndValues), false)
+}
+
+// InfoSDepth acts as InfoS but uses depth to determine which call frame to log.
+// InfoSDepth(0, "msg") is the same as InfoS("msg").
+func InfoSDepth(depth int, msg string, keysAndValues ...interface{}) {
+	logDepth(infoLog, depth+1, structuredEntry(nil, msg, keysAndValues), false)
 }
 
 // Warning logs to the WARNING and INFO logs.
 // Arguments are handled in the manner of fmt.Print; a newline is appended if missing.
 func Warning(args ...interface{}) {
-	klogv2.WarningDepth(1, fmt.Sprint(args...))
+	printDepth(warningLog, 1, fmt.Sprint(args...))
 }
 
 // WarningDepth acts as Warning but uses depth to determine which call frame to log.
 // WarningDepth(0, "msg") is the same as Warning("msg").
 func WarningDepth(depth int, args ...interface{}) {
-	klogv2.WarningDepth(depth+1, fmt.Sprint(args...))
+	printDepth(warningLog, depth+1, fmt.Sprint(args...))
 }
 
 // Warningln logs to the WARNING and INFO logs.
 // Arguments are handled in the manner of fmt.Println; a newline is always appended.
 func Warningln(args ...interface{}) {
-	klogv2.WarningDepth(1, fmt.Sprintln(args...))
+	printDepth(warningLog, 1, fmt.Sprintln(args...))
 }
 
 // Warningf logs to the WARNING and INFO logs.
 // Arguments are handled in the manner of fmt.Printf; a newline is appended if missing.
 func Warningf(format string, args ...interface{}) {
-	klogv2.WarningDepth(1, fmt.Sprintf(format, args...))
+	printDepth(warningLog, 1, fmt.Sprintf(format, args...))
 }
 
 // Error logs to the ERROR, WARNING, and INFO logs.
 // Arguments are handled in the manner of fmt.Print; a newline is appended if missing.
 func Error(args ...interface{}) {
-	klogv2.ErrorDepth(1, fmt.Sprint(args...))
+	printDepth(errorLog, 1, fmt.Sprint(args...))
 }
 
 // ErrorDepth acts as Error but uses depth to determine which call frame to log.
 // ErrorDepth(0, "msg") is the same as Error("msg").
 func ErrorDepth(depth int, args ...interface{}) {
-	klogv2.ErrorDepth(depth+1, fmt.Sprint(args...))
+	printDepth(errorLog, depth+1, fmt.Sprint(args...))
 }
 
 // Errorln logs to the ERROR, WARNING, and INFO logs.
 // Arguments are handled in the manner of fmt.Println; a newline is always appended.
 func Errorln(args ...interface{}) {
-	klogv2.ErrorDepth(1, fmt.Sprintln(args...))
+	printDepth(errorLog, 1, fmt.Sprintln(args...))
 }
 
 // Errorf logs to the ERROR, WARNING, and INFO logs.
 // Arguments are handled in the manner of fmt.Printf; a newline is appended if missing.
 func Errorf(format string, args ...interface{}) {
-	klogv2.ErrorDepth(1, fmt.Sprintf(format, args...))
+	printDepth(errorLog, 1, fmt.Sprintf(format, args...))
+}
+
+// ErrorS structured logs to the ERROR, WARNING, and INFO logs.
+// the err argument used as "err" field of log line.
+// The msg argument used to add constant description to the log line.
+// The key/value pairs would be join by "=" ; a newline is always appended.
+//
+// Basic examples:
+// >> klog.ErrorS(err, "Failed to update pod status")
+// output:
+// >> E1025 00:15:15.525108       1 controller_utils.go:114] "Failed to update pod status" err="timeout"
+func ErrorS(err error, msg string, keysAndValues ...interface{}) {
+	logDepth(errorLog, 1, structuredEntry(err, msg, keysAndValues), false)
+}
+
+// ErrorSDepth acts as ErrorS but uses depth to determine which call frame to log.
+// ErrorSDepth(0, err, "msg") is the same as ErrorS(err, "msg").
+func ErrorSDepth(depth int, err error, msg string, keysAndValues ...interface{}) {
+	logDepth(errorLog, depth+1, structuredEntry(err, msg, keysAndValues), false)
 }
 
 // Fatal logs to the FATAL, ERROR, WARNING, and INFO logs,
 // including a stack trace of all running goroutines, then calls os.Exit(255).
 // Arguments are handled in the manner of fmt.Print; a newline is appended if missing.
 func Fatal(args ...interface{}) {
-	klogv2.FatalDepth(1, fmt.Sprint(args...))
+	printDepth(fatalLog, 1, fmt.Sprint(args...))
 }
 
 // FatalDepth acts as Fatal but uses depth to determine which call frame to log.
 // FatalDepth(0, "msg") is the same as Fatal("msg").
 func FatalDepth(depth int, args ...interface{}) {
-	klogv2.FatalDepth(depth+1, fmt.Sprint(args...))
+	printDepth(fatalLog, depth+1, fmt.Sprint(args...))
 }
 
 // Fatalln logs to the FATAL, ERROR, WARNING, and INFO logs,
 // including a stack trace of all running goroutines, then calls os.Exit(255).
 // Arguments are handled in the manner of fmt.Println; a newline is always appended.
 func Fatalln(args ...interface{}) {
-	klogv2.FatalDepth(1, fmt.Sprintln(args...))
+	printDepth(fatalLog, 1, fmt.Sprintln(args...))
 }
 
 // Fatalf logs to the FATAL, ERROR, WARNING, and INFO logs,
 // including a stack trace of all running goroutines, then calls os.Exit(255).
 // Arguments are handled in the manner of fmt.Printf; a newline is appended if missing.
 func Fatalf(format string, args ...interface{}) {
-	klogv2.FatalDepth(1, fmt.Sprintf(format, args...))
+	printDepth(fatalLog, 1, fmt.Sprintf(format, args...))
 }
 
 // fatalNoStacks is non-zero if we are to exit without dumping goroutine stacks.
@@ -394,22 +631,113 @@ var fatalNoStacks uint32
 // Exit logs to the FATAL, ERROR, WARNING, and INFO logs, then calls os.Exit(1).
 // Arguments are handled in the manner of fmt.Print; a newline is appended if missing.
 func Exit(args ...interface{}) {
-	klogv2.ExitDepth(1, fmt.Sprint(args...))
+	exitDepth(1, fmt.Sprint(args...))
 }
 
 // ExitDepth acts as Exit but uses depth to determine which call frame to log.
 // ExitDepth(0, "msg") is the same as Exit("msg").
 func ExitDepth(depth int, args ...interface{}) {
-	klogv2.ExitDepth(depth+1, fmt.Sprint(args...))
+	exitDepth(depth+1, fmt.Sprint(args...))
 }
 
 // Exitln logs to the FATAL, ERROR, WARNING, and INFO logs, then calls os.Exit(1).
 func Exitln(args ...interface{}) {
-	klogv2.ExitDepth(1, fmt.Sprintln(args...))
+	exitDepth(1, fmt.Sprintln(args...))
 }
 
 // Exitf logs to the FATAL, ERROR, WARNING, and INFO logs, then calls os.Exit(1).
 // Arguments are handled in the manner of fmt.Printf; a newline is appended if missing.
 func Exitf(format string, args ...interface{}) {
-	klogv2.ExitDepth(1, fmt.Sprintf(format, args...))
+	exitDepth(1, fmt.Sprintf(format, args...))
+}
+
+// printDepth logs msg with severity s for the call frame depth frames above
+// its caller. The logging functions of this package go through printDepth,
+// exitDepth or logDepth.
+func printDepth(s severity, depth int, msg string) {
+	logDepth(s, depth+1, &Entry{Message: msg}, false)
+}
+
+// exitDepth is like printDepth for the Exit functions.
+func exitDepth(depth int, msg string) {
+	logDepth(fatalLog, depth+1, &Entry{Message: msg}, true)
+}
+
+// logDepth prepares the message of e, passes e to the sinks and logs it
+// with the formatter of the output format, or else with klog v2.
+func logDepth(s severity, depth int, e *Entry, exit bool) {
+	f, sinks := currentFormatter(), currentSinks()
+	if f == nil && s == fatalLog && handlesFatal() {
+		f = GlogFormatter{}
+	}
+	if f != nil || len(sinks) > 0 {
+		text := e.Text()
+		prepareEntry(s, e)
+		fillEntry(s, depth+1, e)
+		for _, sink := range sinks {
+			sink.Log(e)
+		}
+		if f != nil {
+			logFormatted(f, s, e, exit)
+			return
+		}
+		e = &Entry{Message: text}
+	}
+	msg := prepare(s, e.Text())
+	switch {
+	case exit:
+		klogv2.ExitDepth(depth+1, msg)
+	case s == infoLog:
+		klogv2.InfoDepth(depth+1, msg)
+	case s == warningLog:
+		klogv2.WarningDepth(depth+1, msg)
+	case s == errorLog:
+		klogv2.ErrorDepth(depth+1, msg)
+	default:
+		klogv2.FatalDepth(depth+1, msg)
+	}
+}
+
+// prepare applies redaction, truncation and the multi-line mode to a message
+// of severity s.
+func prepare(s severity, msg string) string {
+	return multilineMode(s).apply(truncate(s, redact(msg)))
+}
+
+// prepareEntry applies prepare to the message of e, and redaction and
+// truncation to its error and to the string values of its key/value pairs,
+// which are copied before they are changed.
+func prepareEntry(s severity, e *Entry) {
+	e.Message = prepare(s, e.Message)
+	if e.Err != nil {
+		msg := e.Err.Error()
+		if prepared := truncate(s, redact(msg)); prepared != msg {
+			e.Err = errors.New(prepared)
+		}
+	}
+	copied := false
+	for i := 1; i < len(e.KeysAndValues); i += 2 {
+		v, ok := e.KeysAndValues[i].(string)
+		if !ok {
+			continue
+		}
+		if prepared := truncate(s, redact(v)); prepared != v {
+			if !copied {
+				e.KeysAndValues = append([]interface{}(nil), e.KeysAndValues...)
+				copied = true
+			}
+			e.KeysAndValues[i] = prepared
+		}
+	}
+}
+
+// structuredEntry returns the entry of a call to InfoS, ErrorS or their
+// variants.
+func structuredEntry(err error, msg string, keysAndValues []interface{}) *Entry {
+	e := &Entry{Message: msg, Err: err, KeysAndValues: keysAndValues, Structured: true}
+	if len(e.KeysAndValues) >= 2 && e.KeysAndValues[0] == LoggerKey {
+		e.Logger = fmt.Sprint(e.KeysAndValues[1])
+		e.KeysAndValues = e.KeysAndValues[2:]
+	}
+	return e
 }
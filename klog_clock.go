@@ -0,0 +1,79 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The source of the current time.
+
+package klog
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of the current time for the time stamps of log lines,
+// the names of log files, rotation and rate limiting.
+type Clock interface {
+	Now() time.Time
+}
+
+// The ClockFunc type is an adapter to allow the use of ordinary functions
+// as clocks.
+type ClockFunc func() time.Time
+
+// Now calls f().
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+var clock struct {
+	mu sync.RWMutex
+	c  Clock // nil for the real clock
+}
+
+// SetClock replaces the clock, typically to make tests deterministic.
+// SetClock(nil) restores the real clock. klog v2 always uses the real clock,
+// so while another clock is set, the shim formats glog headers, see
+// SetFormatter, and manages the log files of the severities without an
+// output.
+func SetClock(c Clock) {
+	clock.mu.Lock()
+	clock.c = c
+	clock.mu.Unlock()
+	if c != nil {
+		logFiles.install(false)
+	}
+}
+
+// Now returns the current time of the clock, in the time zone of the time
+// stamps, for outputs and sinks that add time stamps of their own.
+func Now() time.Time {
+	return logTime()
+}
+
+// timeNow returns the current time of the clock.
+func timeNow() time.Time {
+	clock.mu.RLock()
+	defer clock.mu.RUnlock()
+	if clock.c == nil {
+		return time.Now()
+	}
+	return clock.c.Now()
+}
+
+// clockSet reports whether a clock other than the real one is set.
+func clockSet() bool {
+	clock.mu.RLock()
+	defer clock.mu.RUnlock()
+	return clock.c != nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	. "k8s.io/klog"
+	klogv2 "k8s.io/klog/v2"
+)
+
+func TestClockHeader(t *testing.T) {
+	for _, dirHeader := range []string{"false", "true"} {
+		t.Run("add_dir_header="+dirHeader, func(t *testing.T) {
+			_, testCleanup := testSetup(t, "add_dir_header", dirHeader)
+			defer testCleanup()
+			SetClock(ClockFunc(func() time.Time {
+				return time.Date(2006, time.January, 2, 15, 4, 5, 678901000, time.Local)
+			}))
+
+			Info("test")
+			_, _, line, _ := runtime.Caller(0)
+			file := "klog_clock_test.go"
+			if dirHeader == "true" {
+				file = "klog/" + file
+			}
+			want := fmt.Sprintf("I0102 15:04:05.678901 %7d %s:%d] test\n", os.Getpid(), file, line-1)
+			if contents(infoLog) != want {
+				t.Errorf("log format error: got:\n\t%q\nwant:\t%q", contents(infoLog), want)
+			}
+		})
+	}
+}
+
+func TestClockRollover(t *testing.T) {
+	_, testCleanup := testSetup(t)
+	defer testCleanup()
+	defer func(maxSize uint64) { klogv2.MaxSize = maxSize }(klogv2.MaxSize)
+	klogv2.MaxSize = 512
+	for _, name := range severityName {
+		SetOutputBySeverity(name, nil)
+	}
+	now := time.Date(2020, time.July, 18, 10, 0, 0, 0, time.Local)
+	SetClock(ClockFunc(func() time.Time { return now }))
+	link := filepath.Join(os.TempDir(), filepath.Base(os.Args[0])+".INFO")
+
+	Info("x") // Be sure we have a file.
+	Flush()
+	fname0, err := os.Readlink(link)
+	if err != nil {
+		t.Fatal("info wasn't created")
+	}
+	Info(strings.Repeat("x", int(klogv2.MaxSize))) // force a rollover
+	// The next log file gets a name with a different time stamp without
+	// waiting for a second.
+	now = now.Add(time.Second)
+	Info("x") // create a new file
+	Flush()
+
+	fname1, err := os.Readlink(link)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fname0 == fname1 || !strings.Contains(fname1, ".20200718-100001.") {
+		t.Errorf("info.f.Name did not change to the time of the clock: %v, %v", fname0, fname1)
+	}
+	fileinfo, err := os.Stat(link)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fileinfo.Size() >= int64(klogv2.MaxSize) {
+		t.Errorf("file size was not reset: %d", fileinfo.Size())
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Collapsing of duplicate log lines.
+
+package klog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// dedupeT is the state of duplicate collapsing, for each output.
+type dedupeT struct {
+	mu     sync.Mutex // governs access to everything below
+	window time.Duration
+	// last is the key of the last line written to the output.
+	last [numSeverity][]byte
+	// repeated is the number of duplicates of last that were not
+	// written, and repeatedLine the most recent of them, with its entry
+	// if the shim formatted it.
+	repeated      [numSeverity]int
+	repeatedLine  [numSeverity][]byte
+	repeatedEntry [numSeverity]*Entry
+	// w is where the pending summary is written to.
+	w     [numSeverity]io.Writer
+	timer [numSeverity]*time.Timer
+}
+
+var dedupe dedupeT
+
+// SetCollapseDuplicates makes each output collapse consecutive identical
+// lines, that is lines with the same severity, caller and message, into one
+// line followed by "last message repeated N times", like syslogd does. The
+// summary is written when a different line is logged, on Flush, or once
+// window has passed since the first duplicate. A window of zero disables
+// collapsing. Lines logged before the call are not considered duplicates.
+// Like SetAsyncOutput, this applies to the outputs set with
+// SetOutput or SetOutputBySeverity and to log files, which are managed by the
+// shim for severities without an output, but not to standard error.
+func SetCollapseDuplicates(window time.Duration) {
+	dedupe.flush()
+	dedupe.mu.Lock()
+	dedupe.window = window
+	dedupe.last = [numSeverity][]byte{}
+	dedupe.mu.Unlock()
+	if window > 0 {
+		logFiles.install(false)
+	}
+	installOutputs()
+}
+
+// enabled reports whether duplicate collapsing is enabled.
+func (d *dedupeT) enabled() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.window > 0
+}
+
+// flush writes the pending summaries of all outputs.
+func (d *dedupeT) flush() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for s := infoLog; s < numSeverity; s++ {
+		d.writeSummary(s)
+	}
+}
+
+// writeSummary writes the summary for the duplicates of output s, if any.
+// d.mu is held.
+func (d *dedupeT) writeSummary(s severity) {
+	if d.timer[s] != nil {
+		d.timer[s].Stop()
+		d.timer[s] = nil
+	}
+	if d.repeated[s] == 0 {
+		return
+	}
+	line := d.repeatedLine[s]
+	msg := fmt.Sprintf("last message repeated %d times", d.repeated[s])
+	var summary []byte
+	if e, f := d.repeatedEntry[s], currentFormatter(); e != nil && f != nil {
+		summary = f.Format(&Entry{Severity: e.Severity, Time: e.Time, PID: e.PID, File: e.File, Line: e.Line,
+			Function: e.Function, Logger: e.Logger, Message: msg})
+	} else {
+		if hasHeader(line) {
+			if i := bytes.Index(line, []byte("] ")); i >= 0 {
+				summary = append(summary, line[:i+2]...)
+			}
+		}
+		summary = append(summary, msg+"\n"...)
+	}
+	d.w[s].Write(summary) // ignore error
+	d.repeated[s] = 0
+	d.repeatedLine[s] = nil
+	d.repeatedEntry[s] = nil
+}
+
+// hasHeader reports whether a log line starts with a glog header, as in
+// Lmmdd hh:mm:ss.uuuuuu.
+func hasHeader(p []byte) bool {
+	return headerTimeEnd(p) >= 0
+}
+
+// headerTimeEnd returns the index of the space after the time stamp of a log
+// line with a glog header, or -1. The time stamp is the first part of the
+// line with a colon and a decimal point, in any of the TimestampFormats.
+func headerTimeEnd(p []byte) int {
+	if severityOf(p, numSeverity) == numSeverity {
+		return -1
+	}
+	dot := bytes.IndexByte(p, '.')
+	if dot < 0 || bytes.IndexByte(p[:dot], ':') < 0 {
+		return -1
+	}
+	space := bytes.IndexByte(p[dot:], ' ')
+	if space < 0 {
+		return -1
+	}
+	return dot + space
+}
+
+// dedupeKey returns the part of a log line of klog v2 that decides whether
+// it is a duplicate: the line without its time stamp.
+func dedupeKey(p []byte) []byte {
+	end := headerTimeEnd(p)
+	if end < 0 {
+		return append([]byte(nil), p...)
+	}
+	key := make([]byte, 0, len(p)-end+1)
+	return append(append(key, p[0]), p[end:]...)
+}
+
+// entryKey returns what decides whether a line that the shim formatted is a
+// duplicate: its severity, caller and message.
+func entryKey(e *Entry) []byte {
+	return []byte(fmt.Sprintf("%s %s:%d %s", e.Severity, e.File, e.Line, e.Text()))
+}
+
+// dedupeOutput is the klog v2 output for a severity while duplicate
+// collapsing is enabled.
+type dedupeOutput struct {
+	sev severity
+	w   io.Writer
+}
+
+func (o *dedupeOutput) Write(p []byte) (int, error) {
+	return o.writeLine(p, lineInfo{sev: severityOf(p, o.sev)})
+}
+
+func (o *dedupeOutput) writeLine(p []byte, l lineInfo) (int, error) {
+	d := &dedupe
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var key []byte
+	if l.entry != nil {
+		key = entryKey(l.entry)
+	} else {
+		key = dedupeKey(p)
+	}
+	if d.window > 0 && l.sev != fatalLog && bytes.Equal(key, d.last[o.sev]) {
+		if d.repeated[o.sev] == 0 {
+			s := o.sev
+			d.timer[s] = time.AfterFunc(d.window, func() {
+				d.mu.Lock()
+				defer d.mu.Unlock()
+				d.writeSummary(s)
+			})
+		}
+		d.repeated[o.sev]++
+		d.repeatedLine[o.sev] = append(d.repeatedLine[o.sev][:0], p...)
+		d.repeatedEntry[o.sev] = nil
+		if l.entry != nil {
+			e := *l.entry
+			e.Err, e.KeysAndValues = nil, nil
+			d.repeatedEntry[o.sev] = &e
+		}
+		d.w[o.sev] = o.w
+		return len(p), nil
+	}
+	d.writeSummary(o.sev)
+	d.last[o.sev] = key
+	return writeLine(o.w, p, l)
+}
@@ -0,0 +1,164 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "k8s.io/klog"
+)
+
+// syncWriter is an output that can be read while it is being written to.
+type syncWriter struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *syncWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+func TestCollapseDuplicates(t *testing.T) {
+	_, testCleanup := testSetup(t)
+	defer testCleanup()
+	SetCollapseDuplicates(time.Hour)
+	defer SetCollapseDuplicates(0)
+
+	for i := 0; i < 3; i++ {
+		Info("a")
+	}
+	Info("b")
+	for i := 0; i < 2; i++ {
+		Info("b")
+	}
+	Info("a")
+	Flush()
+
+	expected := []string{"a", "last message repeated 2 times", "b", "b", "last message repeated 1 times", "a"}
+	if got := messages(contents(infoLog)); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+	// The summary has the header of the last duplicate.
+	lines := strings.Split(contents(infoLog), "\n")
+	if !strings.HasPrefix(lines[1], "I") || !strings.Contains(lines[1], "klog_dedupe_test.go:") {
+		t.Errorf("summary has an unexpected header: %q", lines[1])
+	}
+}
+
+func TestCollapseDuplicatesInterleavedSeverities(t *testing.T) {
+	_, testCleanup := testSetup(t)
+	defer testCleanup()
+	SetCollapseDuplicates(time.Hour)
+	defer SetCollapseDuplicates(0)
+
+	for i := 0; i < 3; i++ {
+		Warning("x")
+		Error("x")
+	}
+	Flush()
+
+	// Lines of different severities are never duplicates.
+	for _, s := range []severity{infoLog, warningLog} {
+		expected := []string{"x", "x", "x", "x", "x", "x"}
+		if got := messages(contents(s)); strings.Join(got, ",") != strings.Join(expected, ",") {
+			t.Errorf("%s: got %q, expected %q", severityName[s], got, expected)
+		}
+	}
+	expected := []string{"x", "last message repeated 2 times"}
+	if got := messages(contents(errorLog)); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("ERROR: got %q, expected %q", got, expected)
+	}
+}
+
+func TestCollapseDuplicatesFlushWindow(t *testing.T) {
+	_, testCleanup := testSetup(t)
+	defer testCleanup()
+	w := new(syncWriter)
+	SetOutputBySeverity("INFO", w)
+	SetCollapseDuplicates(10 * time.Millisecond)
+	defer SetCollapseDuplicates(0)
+
+	for i := 0; i < 3; i++ {
+		Info("a")
+	}
+	expected := []string{"a", "last message repeated 2 times"}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		got := messages(w.String())
+		if strings.Join(got, ",") == strings.Join(expected, ",") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %q, expected %q", got, expected)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCollapseDuplicatesReset(t *testing.T) {
+	_, testCleanup := testSetup(t)
+	defer testCleanup()
+	SetCollapseDuplicates(time.Hour)
+	defer SetCollapseDuplicates(0)
+
+	for i := 0; i < 2; i++ {
+		// The line logged before the second call is not a duplicate.
+		SetCollapseDuplicates(time.Hour)
+		Info("a")
+	}
+	Flush()
+
+	expected := []string{"a", "a"}
+	if got := messages(contents(infoLog)); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
+
+func TestCollapseDuplicatesLogfmt(t *testing.T) {
+	_, testCleanup := testSetup(t, "log_format", "logfmt")
+	defer testCleanup()
+	SetCollapseDuplicates(time.Hour)
+	defer SetCollapseDuplicates(0)
+
+	for i := 0; i < 4; i++ {
+		pod := "kubedns"
+		if i == 3 {
+			pod = "coredns"
+		}
+		InfoS("a", "pod", pod)
+	}
+	Flush()
+
+	expected := []string{"a", "last message repeated 2 times", "a"}
+	if got := formatMessages("logfmt", contents(infoLog)); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+	// The summary is a logfmt line with the caller of the duplicates.
+	lines := strings.Split(contents(infoLog), "\n")
+	if !strings.Contains(lines[1], " level=info caller=klog_dedupe_test.go:") {
+		t.Errorf("summary has an unexpected format: %q", lines[1])
+	}
+}
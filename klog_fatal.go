@@ -0,0 +1,149 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Hooks that run before a FATAL line makes the program exit, and the exit
+// function.
+
+package klog
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// FatalInfo describes a FATAL line that makes the program exit.
+type FatalInfo struct {
+	Entry
+	// Stacks holds the stack traces of all goroutines that are written
+	// after the line of the Fatal functions. It is nil for the Exit
+	// functions.
+	Stacks []byte
+	// ExitCode is the code the program exits with, 255 for the Fatal
+	// functions and 1 for the Exit functions.
+	ExitCode int
+}
+
+// fatalHookTimeout is how long the hooks may take before the program exits
+// anyway. fatal.mu governs access to it.
+var fatalHookTimeout = 10 * time.Second
+
+var fatal struct {
+	mu    sync.Mutex // governs access to everything below
+	hooks []func(FatalInfo)
+	// exit replaces os.Exit if set, see SetExitFunc.
+	exit func(code int)
+}
+
+// OnFatal registers a hook that runs when a FATAL line has been written,
+// before the program exits, for example to flush sinks that klog does not
+// know about, dump recent log lines or record metrics. The hooks run in the
+// order in which they were registered. If they take longer than 10 seconds
+// in total, or one of them panics, the program exits without waiting for
+// the rest.
+//
+// While hooks are registered, the shim writes FATAL lines and their stack
+// traces itself rather than klog v2, so they are not counted in Stats.
+func OnFatal(hook func(FatalInfo)) {
+	fatal.mu.Lock()
+	defer fatal.mu.Unlock()
+	fatal.hooks = append(fatal.hooks, hook)
+}
+
+// SetExitFunc replaces os.Exit as the function that ends the program after a
+// FATAL line, so that tests can check the Fatal and Exit functions without a
+// helper process. The line, its stack traces and the OnFatal hooks are
+// written and run before exit is called; if exit returns, so does the Fatal
+// or Exit function. SetExitFunc(nil) restores os.Exit.
+//
+// Like OnFatal, this makes the shim write FATAL lines itself.
+func SetExitFunc(exit func(code int)) {
+	fatal.mu.Lock()
+	defer fatal.mu.Unlock()
+	fatal.exit = exit
+}
+
+// ExitPanic is the value that PanicExit panics with.
+type ExitPanic struct {
+	// Code is the code the program would have exited with.
+	Code int
+}
+
+func (p ExitPanic) Error() string {
+	return fmt.Sprintf("klog: exit with code %d", p.Code)
+}
+
+// PanicExit is an exit function for SetExitFunc that panics with an
+// ExitPanic instead of exiting, which runs the deferred functions and lets
+// a test recover:
+//
+//	klog.SetExitFunc(klog.PanicExit)
+//	defer klog.SetExitFunc(nil)
+//	defer func() {
+//		if p, ok := recover().(klog.ExitPanic); !ok || p.Code != 255 {
+//			t.Errorf("Fatal did not exit with 255")
+//		}
+//	}()
+//	codeUnderTest()
+func PanicExit(code int) {
+	panic(ExitPanic{Code: code})
+}
+
+// handlesFatal reports whether the shim has to write FATAL lines itself to
+// run the hooks or to call a replaced exit function.
+func handlesFatal() bool {
+	fatal.mu.Lock()
+	defer fatal.mu.Unlock()
+	return len(fatal.hooks) > 0 || fatal.exit != nil
+}
+
+// runFatalHooks runs the hooks, waiting for at most fatalHookTimeout.
+func runFatalHooks(info FatalInfo) {
+	fatal.mu.Lock()
+	hooks, timeout := fatal.hooks, fatalHookTimeout
+	fatal.mu.Unlock()
+	if len(hooks) == 0 {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if r := recover(); r != nil {
+				fmt.Fprintln(os.Stderr, "klog: OnFatal hook panicked:", r)
+			}
+		}()
+		for _, hook := range hooks {
+			hook(info)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		fmt.Fprintln(os.Stderr, "klog: OnFatal hooks took longer than", timeout)
+	}
+}
+
+// exitProgram ends the program with code.
+func exitProgram(code int) {
+	fatal.mu.Lock()
+	exit := fatal.exit
+	fatal.mu.Unlock()
+	if exit == nil {
+		exit = os.Exit
+	}
+	exit(code)
+}
@@ -0,0 +1,168 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	. "k8s.io/klog"
+)
+
+func TestOnFatal(t *testing.T) {
+	_, testCleanup := testSetup(t, "stderrthreshold", "FATAL")
+	defer testCleanup()
+	var codes []int
+	SetExitFunc(func(code int) { codes = append(codes, code) })
+	defer SetExitFunc(nil)
+	defer SetFatalHookTimeout(time.Minute)()
+
+	var infos []FatalInfo
+	var outputAtHook string
+	OnFatal(func(info FatalInfo) {
+		infos = append(infos, info)
+		outputAtHook = contents(fatalLog)
+	})
+	OnFatal(func(info FatalInfo) {
+		infos = append(infos, info)
+	})
+	Fatalf("disk %s", "gone")
+	Exit("bye")
+
+	if len(codes) != 2 || codes[0] != 255 || codes[1] != 1 {
+		t.Fatalf("expected exit codes 255 and 1, got %v", codes)
+	}
+	if len(infos) != 4 {
+		t.Fatalf("expected each hook to run twice, got %d runs", len(infos))
+	}
+	info := infos[0]
+	if info.Severity != "FATAL" || info.Message != "disk gone" || info.File != "klog_fatal_test.go" || info.ExitCode != 255 {
+		t.Errorf("unexpected info %+v", info)
+	}
+	if !strings.HasPrefix(string(info.Stacks), "goroutine ") {
+		t.Errorf("expected stacks, got %q", info.Stacks)
+	}
+	// The line and the stack traces are written before the hooks run.
+	if !strings.Contains(outputAtHook, "] disk gone\ngoroutine ") {
+		t.Errorf("unexpected output when the hook ran %q", outputAtHook)
+	}
+	if info := infos[2]; info.Message != "bye" || info.Stacks != nil || info.ExitCode != 1 {
+		t.Errorf("unexpected info for Exit %+v", info)
+	}
+}
+
+func TestOnFatalTimeout(t *testing.T) {
+	_, testCleanup := testSetup(t, "stderrthreshold", "FATAL")
+	defer testCleanup()
+	exited := make(chan int, 1)
+	SetExitFunc(func(code int) { exited <- code })
+	defer SetExitFunc(nil)
+	defer SetFatalHookTimeout(10 * time.Millisecond)()
+
+	block := make(chan struct{})
+	defer close(block)
+	OnFatal(func(FatalInfo) { <-block })
+	Fatal("stuck")
+
+	select {
+	case code := <-exited:
+		if code != 255 {
+			t.Errorf("expected exit code 255, got %d", code)
+		}
+	default:
+		t.Fatal("blocked hook kept the program from exiting")
+	}
+}
+
+func TestOnFatalPanic(t *testing.T) {
+	_, testCleanup := testSetup(t, "stderrthreshold", "FATAL")
+	defer testCleanup()
+	var codes []int
+	SetExitFunc(func(code int) { codes = append(codes, code) })
+	defer SetExitFunc(nil)
+	defer SetFatalHookTimeout(time.Minute)()
+
+	ran := false
+	OnFatal(func(FatalInfo) { panic("hook failed") })
+	OnFatal(func(FatalInfo) { ran = true })
+	Exitf("bye")
+
+	if len(codes) != 1 || codes[0] != 1 {
+		t.Errorf("expected exit code 1, got %v", codes)
+	}
+	if ran {
+		t.Error("hooks after a panic should not run")
+	}
+}
+
+func TestPanicExit(t *testing.T) {
+	_, testCleanup := testSetup(t, "stderrthreshold", "FATAL")
+	defer testCleanup()
+	SetExitFunc(PanicExit)
+	defer SetExitFunc(nil)
+
+	deferred := false
+	func() {
+		defer func() {
+			r := recover()
+			if p, ok := r.(ExitPanic); !ok || p.Code != 1 {
+				t.Fatalf("expected Exit to exit with 1, got %v", r)
+			}
+		}()
+		defer func() { deferred = true }()
+		Exitf("exiting after %d attempts", 3)
+		t.Fatal("Exitf returned")
+	}()
+
+	if !deferred {
+		t.Error("deferred functions did not run")
+	}
+	if got := messages(contents(fatalLog)); len(got) != 1 || got[0] != "exiting after 3 attempts" {
+		t.Errorf("unexpected output %q", got)
+	}
+}
+
+func TestLogFileExit(t *testing.T) {
+	flagset, testCleanup := testSetup(t, "stderrthreshold", "FATAL")
+	defer testCleanup()
+	missing := filepath.Join(os.TempDir(), "missing")
+	flagset.Set("log_dir", missing)
+	tmpenv := "TMPDIR"
+	if runtime.GOOS == "windows" {
+		tmpenv = "TMP"
+	}
+	defer setEnv(tmpenv, missing)()
+	var codes []int
+	SetExitFunc(func(code int) { codes = append(codes, code) })
+	defer SetExitFunc(nil)
+	defer SetFatalHookTimeout(time.Minute)()
+	var infos []FatalInfo
+	OnFatal(func(info FatalInfo) { infos = append(infos, info) })
+	SetRotationInterval(time.Hour) // the shim manages the log files
+	defer SetRotationInterval(0)
+
+	Info("no log file")
+
+	if len(codes) == 0 || codes[0] != 2 {
+		t.Fatalf("expected exit code 2, got %v", codes)
+	}
+	if len(infos) == 0 || infos[0].ExitCode != 2 || !strings.Contains(infos[0].Message, "exiting because of error") {
+		t.Errorf("unexpected infos %+v", infos)
+	}
+}
@@ -19,8 +19,560 @@
 package klog
 
 import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	klogv2 "k8s.io/klog/v2"
 )
 
 // MaxSize is the maximum size of a log file in bytes.
 var MaxSize uint64 = klogv2.MaxSize
+
+var (
+	pid      = os.Getpid()
+	program  = filepath.Base(os.Args[0])
+	host     = "unknownhost"
+	userName = "unknownuser"
+)
+
+func init() {
+	h, err := os.Hostname()
+	if err == nil {
+		host = shortHostname(h)
+	}
+
+	// On Windows, the Go 'user' package requires netapi32.dll.
+	// This affects Windows Nano Server:
+	//   https://github.com/golang/go/issues/21867
+	// Fallback to using environment variables.
+	if runtime.GOOS == "windows" {
+		u := os.Getenv("USERNAME")
+		if len(u) == 0 {
+			return
+		}
+		// Sanitize the USERNAME since it may contain filepath separators.
+		u = strings.Replace(u, `\`, "_", -1)
+
+		// user.Current().Username normally produces something like 'USERDOMAIN\USERNAME'
+		d := os.Getenv("USERDOMAIN")
+		if len(d) != 0 {
+			userName = d + "_" + u
+		} else {
+			userName = u
+		}
+	} else {
+		current, err := user.Current()
+		if err == nil {
+			userName = current.Username
+		}
+	}
+}
+
+// shortHostname returns its argument, truncating at the first period.
+// For instance, given "www.google.com" it returns "www".
+func shortHostname(hostname string) string {
+	if i := strings.Index(hostname, "."); i >= 0 {
+		return hostname[:i]
+	}
+	return hostname
+}
+
+// logPrefix returns the common prefix of the names of all log files
+// containing tag that were written by this program, including by earlier runs.
+func logPrefix(tag string) string {
+	return fmt.Sprintf("%s.%s.%s.log.%s.", program, host, userName, tag)
+}
+
+// logName returns a new log file name containing tag, with start time t, and
+// the name for the symlink for tag. The names match the ones used by klog v2.
+func logName(tag string, t time.Time) (name, link string) {
+	name = fmt.Sprintf("%s%04d%02d%02d-%02d%02d%02d.%d",
+		logPrefix(tag),
+		t.Year(),
+		t.Month(),
+		t.Day(),
+		t.Hour(),
+		t.Minute(),
+		t.Second(),
+		pid)
+	return name, program + "." + tag
+}
+
+// logDirs lists the candidate directories for new log files.
+func logDirs() []string {
+	var dirs []string
+	if dir := v2flag("log_dir"); dir != "" {
+		dirs = append(dirs, dir)
+	}
+	return append(dirs, os.TempDir())
+}
+
+// create creates a new log file and returns the file and its filename, which
+// contains tag ("INFO", "FATAL", etc.) and t.  If the file is created
+// successfully, create also attempts to update the symlink for that tag, ignoring
+// errors.
+// The startup argument indicates whether this is the initial startup of klog.
+// If startup is true, existing files are opened for appending instead of truncated.
+func create(tag string, t time.Time, startup bool) (f *os.File, filename string, err error) {
+	if logFile := v2flag("log_file"); logFile != "" {
+		f, err := openOrCreate(logFile, startup)
+		if err == nil {
+			return f, logFile, nil
+		}
+		return nil, "", fmt.Errorf("log: unable to create log: %v", err)
+	}
+	dirs := logDirs()
+	if len(dirs) == 0 {
+		return nil, "", errors.New("log: no log dirs")
+	}
+	name, link := logName(tag, t)
+	var lastErr error
+	for _, dir := range dirs {
+		fname := filepath.Join(dir, name)
+		f, err := openOrCreate(fname, startup)
+		if err == nil {
+			symlink := filepath.Join(dir, link)
+			os.Remove(symlink)        // ignore err
+			os.Symlink(name, symlink) // ignore err
+			return f, fname, nil
+		}
+		lastErr = err
+	}
+	return nil, "", fmt.Errorf("log: cannot create log: %v", lastErr)
+}
+
+// The startup argument indicates whether this is the initial startup of klog.
+// If startup is true, existing files are opened for appending instead of truncated.
+func openOrCreate(name string, startup bool) (*os.File, error) {
+	if startup {
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+		return f, err
+	}
+	f, err := os.Create(name)
+	return f, err
+}
+
+// logFilesT holds the log files that the shim manages itself. klog v2 only
+// knows how to rotate log files by size, so when a feature such as
+// time-based rotation is enabled the shim installs its own writers as the
+// klog v2 outputs. They create, name and rotate files the same way klog v2
+// does and are only written to when klog v2 would write to its log files.
+type logFilesT struct {
+	mu sync.Mutex // governs access to everything below
+	// interval is the time-based rotation interval; zero disables it.
+	interval time.Duration
+	// retention limits the old log files kept in the log directory.
+	retention RetentionPolicy
+	// compression is applied to log files after they are rotated.
+	compression Compression
+	// compressing tracks the goroutines compressing rotated log files.
+	compressing sync.WaitGroup
+	// file holds the installed writer for each severity, or nil.
+	file [numSeverity]*syncBuffer
+}
+
+var logFiles logFilesT
+
+// SetRotationInterval makes log files rotate whenever a multiple of d has
+// passed since local midnight (or since the zero time, for intervals longer
+// than a day), in addition to rotating when they reach CalculateMaxSize. A
+// value of zero disables time-based rotation. Setting a non-zero interval
+// replaces the outputs set with SetOutput or SetOutputBySeverity with log
+// files; it is the same as the -log_rotation_interval flag.
+func SetRotationInterval(d time.Duration) {
+	logFiles.mu.Lock()
+	logFiles.interval = d
+	now := logTime()
+	for _, sb := range logFiles.file {
+		if sb != nil && sb.file != nil {
+			sb.next = nextRotation(now, d)
+		}
+	}
+	logFiles.mu.Unlock()
+	if d > 0 {
+		logFiles.install(true)
+	}
+}
+
+// rotationInterval returns the interval set with SetRotationInterval.
+func rotationInterval() time.Duration {
+	logFiles.mu.Lock()
+	defer logFiles.mu.Unlock()
+	return logFiles.interval
+}
+
+// RetentionPolicy limits the log files that are kept in the log directory.
+// The limits apply to all files written by this program on this host as
+// this user, whatever process wrote them, so that files left behind by
+// earlier runs are cleaned up as well. Only the files that this process is
+// currently writing to are never removed. A zero value of any of the fields
+// means no limit.
+type RetentionPolicy struct {
+	// MaxFiles is the maximum number of log files kept per severity.
+	MaxFiles int
+	// MaxTotalBytes is the maximum total size of the log files of all
+	// severities together.
+	MaxTotalBytes uint64
+	// MaxAge is how long a log file is kept after it was last written to.
+	MaxAge time.Duration
+}
+
+// SetRetentionPolicy sets the policy used to prune old log files whenever a
+// log file is created or rotated. Setting a non-zero policy replaces the
+// outputs set with SetOutput or SetOutputBySeverity with log files; it is
+// the same as the -log_max_files, -log_max_total_size and -log_max_age flags.
+// Retention does not apply when logging to a single file with -log_file.
+func SetRetentionPolicy(p RetentionPolicy) {
+	logFiles.mu.Lock()
+	logFiles.retention = p
+	logFiles.mu.Unlock()
+	if p != (RetentionPolicy{}) {
+		logFiles.install(true)
+	}
+}
+
+// retentionPolicy returns the policy set with SetRetentionPolicy.
+func retentionPolicy() RetentionPolicy {
+	logFiles.mu.Lock()
+	defer logFiles.mu.Unlock()
+	return logFiles.retention
+}
+
+// Compression identifies how log files are compressed after rotation.
+type Compression string
+
+const (
+	// NoCompression keeps rotated log files as plain text.
+	NoCompression Compression = ""
+	// GzipCompression compresses rotated log files with gzip and adds a
+	// ".gz" suffix to their names.
+	GzipCompression Compression = "gzip"
+)
+
+// SetCompression sets how log files are compressed after they have been
+// rotated. Compression happens in the background; the symlink for each
+// severity keeps pointing at the uncompressed file that is being written to,
+// and the retention policy counts the compressed sizes. Setting a
+// compression replaces the outputs set with SetOutput or SetOutputBySeverity
+// with log files; it is the same as the -log_compression flag.
+func SetCompression(c Compression) error {
+	switch c {
+	case NoCompression, GzipCompression:
+	default:
+		return fmt.Errorf("unsupported log compression %q", c)
+	}
+	logFiles.mu.Lock()
+	logFiles.compression = c
+	logFiles.mu.Unlock()
+	if c != NoCompression {
+		logFiles.install(true)
+	}
+	return nil
+}
+
+// compression returns the compression set with SetCompression.
+func compression() Compression {
+	logFiles.mu.Lock()
+	defer logFiles.mu.Unlock()
+	return logFiles.compression
+}
+
+// install makes the shim's log file writers the outputs for all severities,
+// or only for the ones that do not have an output yet unless replace is set.
+func (l *logFilesT) install(replace bool) {
+	outputs.mu.Lock()
+	defer outputs.mu.Unlock()
+	for s := infoLog; s < numSeverity; s++ {
+		if outputs.dest[s] != nil && !replace {
+			continue
+		}
+		l.installFile(s)
+	}
+}
+
+// installFile installs the writer for severity s as its output.
+// outputs.mu is held.
+func (l *logFilesT) installFile(s severity) {
+	l.mu.Lock()
+	if l.file[s] == nil {
+		l.file[s] = &syncBuffer{logFiles: l, sev: s}
+	}
+	outputs.dest[s] = l.file[s]
+	l.mu.Unlock()
+	installOutput(s)
+}
+
+// release closes and forgets the writer for severity s after it has been
+// replaced as the output.
+func (l *logFilesT) release(s severity) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if sb := l.file[s]; sb != nil {
+		if sb.file != nil {
+			sb.file.Close()
+		}
+		l.file[s] = nil
+	}
+}
+
+// exit is called if there is trouble creating or writing log files, like
+// klog v2 does for its own files. Like after a FATAL line, the OnFatal hooks
+// run before the exit function of SetExitFunc is called.
+func (l *logFilesT) exit(err error) {
+	msg := fmt.Sprintf("log: exiting because of error: %s", err)
+	fmt.Fprintln(os.Stderr, msg)
+	runFatalHooks(FatalInfo{
+		Entry:    Entry{Severity: severityName[fatalLog], Time: logTime(), PID: pid, Message: msg},
+		ExitCode: 2,
+	})
+	exitProgram(2)
+}
+
+// syncBuffer is the writer installed for one severity. Writes go straight to
+// the file rather than through a buffer: klog v2 does not flush outputs set
+// with SetOutputBySeverity before it exits on Fatal.
+type syncBuffer struct {
+	logFiles *logFilesT
+	sev      severity
+	file     *os.File
+	name     string    // The path of file
+	nbytes   uint64    // The number of bytes written to this file
+	next     time.Time // When the file is due for time-based rotation
+}
+
+func (sb *syncBuffer) Write(p []byte) (n int, err error) {
+	l := sb.logFiles
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file[sb.sev] != sb {
+		// Released while klog v2 was about to write to it.
+		return len(p), nil
+	}
+	now := logTime()
+	switch {
+	case sb.file == nil:
+		err = sb.rotateFile(now, true)
+	case sb.nbytes+uint64(len(p)) >= CalculateMaxSize():
+		err = sb.rotateFile(now, false)
+	case !sb.next.IsZero() && !now.Before(sb.next):
+		err = sb.rotateFile(now, false)
+	}
+	if err != nil {
+		l.exit(err)
+	}
+	n, err = sb.file.Write(p)
+	sb.nbytes += uint64(n)
+	if err != nil {
+		l.exit(err)
+	}
+	return
+}
+
+// rotateFile closes the syncBuffer's file and starts a new one.
+// The startup argument indicates whether this is the initial startup of klog.
+// If startup is true, existing files are opened for appending instead of truncated.
+// l.mu is held.
+func (sb *syncBuffer) rotateFile(now time.Time, startup bool) error {
+	logFile := v2flag("log_file")
+	if sb.file != nil && logFile == "" {
+		// Log file names have a resolution of one second. A file that is
+		// due for rotation within the second it was created in is
+		// continued until the next second instead of being replaced by a
+		// file of the same name.
+		if name, _ := logName(severityName[sb.sev], now); filepath.Base(sb.name) == name {
+			return nil
+		}
+	}
+	if sb.file != nil {
+		sb.file.Close()
+		atomic.AddInt64(&ExtendedStats.rotations[sb.sev], 1)
+		// A single -log_file is truncated instead of rotated.
+		if c := sb.logFiles.compression; c != NoCompression && logFile == "" {
+			sb.logFiles.compressing.Add(1)
+			go func(name string) {
+				defer sb.logFiles.compressing.Done()
+				compressLog(name, c) // ignore err
+			}(sb.name)
+		}
+	}
+	var err error
+	sb.file, sb.name, err = create(severityName[sb.sev], now, startup)
+	sb.nbytes = 0
+	if err != nil {
+		return err
+	}
+	sb.next = nextRotation(now, sb.logFiles.interval)
+	if logFile == "" {
+		sb.logFiles.prune(filepath.Dir(sb.name), now)
+	}
+
+	if v2flag("skip_log_headers") == "true" {
+		return nil
+	}
+
+	// Write header.
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Log file created at: %s\n", now.Format("2006/01/02 15:04:05"))
+	fmt.Fprintf(&buf, "Running on machine: %s\n", host)
+	fmt.Fprintf(&buf, "Binary: Built with %s %s for %s/%s\n", runtime.Compiler, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&buf, "Log line format: [IWEF]%s threadid file:line] msg\n", timestampOptions().Format.description())
+	n, err := sb.file.Write(buf.Bytes())
+	sb.nbytes += uint64(n)
+	return err
+}
+
+// compressLog replaces the log file name with a compressed copy. The copy is
+// written to a hidden temporary file first so that it is never mistaken for
+// a log file before it is complete.
+func compressLog(name string, c Compression) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dir, base := filepath.Split(name)
+	tmp := filepath.Join(dir, "."+base+".tmp")
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	zw.Name = base
+	zw.ModTime = info.ModTime()
+	_, err = io.Copy(zw, src)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		// Keep the modification time for MaxAge.
+		err = os.Chtimes(tmp, info.ModTime(), info.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(tmp, name+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(name)
+}
+
+// oldLog describes a log file that is a candidate for pruning.
+type oldLog struct {
+	path    string
+	size    uint64
+	modTime time.Time
+}
+
+// prune removes the log files in dir that exceed the retention policy,
+// oldest first. Errors are ignored; pruning is retried on the next rotation.
+// l.mu is held.
+func (l *logFilesT) prune(dir string, now time.Time) {
+	p := l.retention
+	if p == (RetentionPolicy{}) {
+		return
+	}
+	inUse := make(map[string]bool)
+	for _, sb := range l.file {
+		if sb != nil && sb.file != nil {
+			inUse[sb.name] = true
+		}
+	}
+
+	var (
+		all   []oldLog
+		total uint64
+	)
+	for s := infoLog; s < numSeverity; s++ {
+		paths, err := filepath.Glob(filepath.Join(dir, logPrefix(severityName[s])+"*"))
+		if err != nil {
+			continue
+		}
+		var logs []oldLog
+		for _, path := range paths {
+			info, err := os.Lstat(path)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			size := uint64(info.Size())
+			total += size
+			if inUse[path] {
+				continue
+			}
+			logs = append(logs, oldLog{path, size, info.ModTime()})
+		}
+		sortOldLogs(logs)
+		// The file in use counts towards MaxFiles.
+		keep := len(logs)
+		if p.MaxFiles > 0 && keep >= p.MaxFiles {
+			keep = p.MaxFiles - 1
+		}
+		for i, log := range logs {
+			if i < len(logs)-keep || (p.MaxAge > 0 && now.Sub(log.modTime) > p.MaxAge) {
+				if os.Remove(log.path) == nil {
+					total -= log.size
+				}
+				continue
+			}
+			all = append(all, log)
+		}
+	}
+
+	if p.MaxTotalBytes == 0 {
+		return
+	}
+	sortOldLogs(all)
+	for _, log := range all {
+		if total <= p.MaxTotalBytes {
+			break
+		}
+		if os.Remove(log.path) == nil {
+			total -= log.size
+		}
+	}
+}
+
+// sortOldLogs sorts logs from the least to the most recently written.
+func sortOldLogs(logs []oldLog) {
+	sort.Slice(logs, func(i, j int) bool {
+		if !logs[i].modTime.Equal(logs[j].modTime) {
+			return logs[i].modTime.Before(logs[j].modTime)
+		}
+		return logs[i].path < logs[j].path
+	})
+}
+
+// nextRotation returns the first interval boundary after t, or the zero time
+// if d is not positive. Intervals that fit into a day are counted from local
+// midnight, so that for example 6h rotates at 00:00, 06:00, 12:00 and 18:00.
+func nextRotation(t time.Time, d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	if d > 24*time.Hour {
+		return t.Truncate(d).Add(d)
+	}
+	year, month, day := t.Date()
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+	return midnight.Add((t.Sub(midnight)/d + 1) * d)
+}
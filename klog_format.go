@@ -0,0 +1,474 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Output formats other than the glog header of klog v2.
+
+package klog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// LoggerKey is the key of a key/value pair that names the logger when it
+// comes first in the key/value pairs of InfoS, ErrorS and their variants,
+// as klogr does for the names given with WithName.
+const LoggerKey = "logger"
+
+// Entry is a log line as it is given to a Formatter.
+type Entry struct {
+	// Severity is "INFO", "WARNING", "ERROR" or "FATAL".
+	Severity string
+	Time     time.Time
+	// PID is the process ID, which the glog header shows as thread ID.
+	PID  int
+	File string
+	Line int
+	// Function is the qualified name of the calling function, as in
+	// "k8s.io/klog.TestInfo".
+	Function string
+	// Logger is the name of the logger, as given by the LoggerKey pair.
+	Logger string
+	// Message is the message, for the functions other than InfoS, ErrorS
+	// and their variants as formatted by fmt and possibly ending with a
+	// newline.
+	Message string
+	// Err is the error passed to ErrorS and its variants.
+	Err           error
+	KeysAndValues []interface{}
+	// Structured is set for the lines of InfoS, ErrorS and their variants.
+	Structured bool
+}
+
+// Text returns the message of e as the glog format shows it: the message
+// itself, or for structured lines the quoted message followed by the error
+// and the key/value pairs, as in
+//
+//	"Pod status updated" pod="kubedns" status="ready"
+func (e *Entry) Text() string {
+	if !e.Structured {
+		return e.Message
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%q", e.Message)
+	if e.Err != nil {
+		fmt.Fprintf(&b, " err=%q", e.Err.Error())
+	}
+	if e.Logger != "" {
+		fmt.Fprintf(&b, " %s=%q", LoggerKey, e.Logger)
+	}
+	for i := 0; i < len(e.KeysAndValues); i += 2 {
+		k, v := e.KeysAndValues[i], kvValue(e.KeysAndValues, i)
+		if _, ok := v.(fmt.Stringer); ok {
+			fmt.Fprintf(&b, " %s=%q", k, v)
+		} else {
+			fmt.Fprintf(&b, " %s=%#v", k, v)
+		}
+	}
+	return b.String()
+}
+
+// missingValue is the value of a key without one.
+const missingValue = "(MISSING)"
+
+// kvValue returns the value of the key at index i of kvs.
+func kvValue(kvs []interface{}, i int) interface{} {
+	if i+1 < len(kvs) {
+		return kvs[i+1]
+	}
+	return missingValue
+}
+
+// A Formatter formats log lines. Format returns the line for e, including
+// the final newline.
+type Formatter interface {
+	Format(e *Entry) []byte
+}
+
+// The FormatterFunc type is an adapter to allow the use of ordinary functions
+// as formatters.
+type FormatterFunc func(e *Entry) []byte
+
+// Format calls f(e).
+func (f FormatterFunc) Format(e *Entry) []byte {
+	return f(e)
+}
+
+var formatting struct {
+	mu sync.RWMutex
+	f  Formatter
+}
+
+// formatters are the output formats that can be chosen with -log_format.
+var formatters = map[string]Formatter{
+	"glog":   GlogFormatter{},
+	"logfmt": LogfmtFormatter{},
+	"json":   JSONFormatter{},
+}
+
+// SetFormatter sets the formatter of all log lines, including those of klogr.
+// The default is GlogFormatter, with which klog v2 formats and writes the
+// lines itself. With other formatters, the shim writes the lines, so they are
+// counted in ExtendedStats rather than Stats and are not passed to a logger
+// set with klogv2.SetLogger. SetFormatter(nil) restores the default.
+func SetFormatter(f Formatter) {
+	if f == nil {
+		f = GlogFormatter{}
+	}
+	formatting.mu.Lock()
+	formatting.f = f
+	formatting.mu.Unlock()
+	if currentFormatter() != nil && v2flag("logtostderr") != "true" {
+		logFiles.install(false)
+	}
+}
+
+// currentFormatter returns the formatter of log lines, or nil if klog v2
+// formats them.
+func currentFormatter() Formatter {
+	formatting.mu.RLock()
+	defer formatting.mu.RUnlock()
+	if _, ok := formatting.f.(GlogFormatter); ok || formatting.f == nil {
+		if timestampOptions() != (TimestampOptions{}) || clockSet() {
+			// klog v2 cannot write other time stamps.
+			return GlogFormatter{}
+		}
+		return nil
+	}
+	return formatting.f
+}
+
+// StructuredOutput reports whether the formatter is not the glog one, in
+// which case logr.Logger implementations such as klogr log key/value pairs
+// with InfoSDepth and ErrorSDepth instead of formatting them into the
+// message.
+func StructuredOutput() bool {
+	formatting.mu.RLock()
+	defer formatting.mu.RUnlock()
+	_, ok := formatting.f.(GlogFormatter)
+	return !ok && formatting.f != nil
+}
+
+// formatValue adapts SetFormatter to the flag.Value interface, by the names
+// of the formatters.
+type formatValue struct{}
+
+func (formatValue) String() string {
+	formatting.mu.RLock()
+	defer formatting.mu.RUnlock()
+	if formatting.f == nil {
+		return "glog"
+	}
+	for name, f := range formatters {
+		if f == formatting.f {
+			return name
+		}
+	}
+	return ""
+}
+
+func (formatValue) Set(value string) error {
+	f, ok := formatters[value]
+	if !ok {
+		return fmt.Errorf("unknown log format %q", value)
+	}
+	SetFormatter(f)
+	return nil
+}
+
+// fillEntry sets the severity, time and caller of e, the call frame depth
+// frames above the caller of fillEntry.
+func fillEntry(s severity, depth int, e *Entry) {
+	pc, file, line, ok := runtime.Caller(depth + 1)
+	if fn := runtime.FuncForPC(pc); ok && fn != nil {
+		e.Function = fn.Name()
+	}
+	if !ok {
+		file = "???"
+		line = 1
+	} else if slash := strings.LastIndex(file, "/"); slash >= 0 {
+		path := file
+		file = path[slash+1:]
+		if v2flag("add_dir_header") == "true" {
+			if dirsep := strings.LastIndex(path[:slash], "/"); dirsep >= 0 {
+				file = path[dirsep+1:]
+			}
+		}
+	}
+	e.Severity, e.Time, e.PID, e.File, e.Line = severityName[s], logTime(), pid, file, line
+}
+
+// logFormatted logs e with formatter f, writing to the outputs like klog v2
+// does. The line is counted in ExtendedStats instead of Stats.
+func logFormatted(f Formatter, s severity, e *Entry, exit bool) {
+	data := f.Format(e)
+	if at := v2flag("log_backtrace_at"); at != "" && at == fmt.Sprintf("%s:%d", e.File, e.Line) {
+		data = append(data, stacks(false)...)
+	}
+	writeFormatted(s, e, data)
+	if s != fatalLog {
+		return
+	}
+	// The exit function of SetExitFunc may return.
+	defer async.endFatal()
+	info := FatalInfo{Entry: *e, ExitCode: 1}
+	if !exit {
+		info.Stacks, info.ExitCode = stacks(true), 255
+		writeTrace(s, formatTrace(info.Stacks))
+	}
+	runFatalHooks(info)
+	timeoutFlush(10 * time.Second)
+	exitProgram(info.ExitCode)
+}
+
+// toStderr reports whether lines of severity s are also written to standard
+// error.
+func toStderr(s severity) bool {
+	if v2flag("alsologtostderr") == "true" {
+		return true
+	}
+	threshold, _ := strconv.Atoi(v2flag("stderrthreshold"))
+	return int(s) >= threshold
+}
+
+// writeFormatted writes the line of e, of severity s, to standard error and
+// to the outputs of s and the severities below it.
+func writeFormatted(s severity, e *Entry, data []byte) {
+	defer ExtendedStats.countLine(s, len(data))
+	if v2flag("logtostderr") == "true" {
+		os.Stderr.Write(data)
+		return
+	}
+	if toStderr(s) {
+		os.Stderr.Write(data)
+	}
+	if output(s) == nil || output(infoLog) == nil {
+		logFiles.install(false)
+	}
+	outputs.mu.Lock()
+	defer outputs.mu.Unlock()
+	if v2flag("log_file") != "" {
+		// All severities are written to the same file.
+		writeLine(outputs.installed[infoLog], data, lineInfo{sev: s, entry: e})
+		return
+	}
+	for t := s; t >= infoLog; t-- {
+		if w := outputs.installed[t]; w != nil {
+			writeLine(w, data, lineInfo{sev: s, entry: e})
+		}
+	}
+}
+
+// writeTrace writes the stack trace after a FATAL line.
+func writeTrace(s severity, trace []byte) {
+	if v2flag("logtostderr") == "true" || toStderr(s) {
+		os.Stderr.Write(trace)
+	}
+	outputs.mu.Lock()
+	defer outputs.mu.Unlock()
+	for t := fatalLog; t >= infoLog; t-- {
+		if w := outputs.installed[t]; w != nil {
+			writeLine(w, trace, lineInfo{sev: s})
+		}
+	}
+}
+
+// stacks is a wrapper for runtime.Stack that attempts to recover the data
+// for all goroutines.
+func stacks(all bool) []byte {
+	// We don't know how big the traces are, so grow a few times if they don't fit. Start large, though.
+	n := 10000
+	if all {
+		n = 100000
+	}
+	var trace []byte
+	for i := 0; i < 5; i++ {
+		trace = make([]byte, n)
+		nbytes := runtime.Stack(trace, all)
+		if nbytes < len(trace) {
+			return trace[:nbytes]
+		}
+		n *= 2
+	}
+	return trace
+}
+
+// timeoutFlush calls Flush and returns when it completes or after timeout
+// elapses, whichever happens first.
+func timeoutFlush(timeout time.Duration) {
+	done := make(chan bool, 1)
+	go func() {
+		Flush()
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		fmt.Fprintln(os.Stderr, "klog: Flush took longer than", timeout)
+	}
+}
+
+// GlogFormatter formats lines with the glog header, as in
+//
+//	I1016 12:00:00.000000    1234 file.go:12] message
+//
+// It is the default formatter, which klog v2 implements itself. Wrapping it
+// in another formatter makes the shim write the lines instead.
+type GlogFormatter struct{}
+
+// Format implements Formatter.
+func (GlogFormatter) Format(e *Entry) []byte {
+	var b bytes.Buffer
+	if v2flag("skip_headers") != "true" {
+		line := e.Line
+		if line < 0 {
+			line = 0 // not a real line number, but acceptable
+		}
+		// Lmmdd hh:mm:ss.uuuuuu threadid file:line]
+		fmt.Fprintf(&b, "%c%s %7d %s:%d] ", e.Severity[0], e.Time.Format(timestampOptions().Format.layout()),
+			e.PID, e.File, line)
+	}
+	b.WriteString(e.Text())
+	if b.Len() == 0 || b.Bytes()[b.Len()-1] != '\n' {
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// LogfmtFormatter formats lines as logfmt, as in
+//
+//	ts=2020-10-16T12:00:00.000000+02:00 level=info caller=file.go:12 msg="message" key=value
+type LogfmtFormatter struct{}
+
+// Format implements Formatter.
+func (LogfmtFormatter) Format(e *Entry) []byte {
+	var b bytes.Buffer
+	b.WriteString("ts=")
+	b.WriteString(e.Time.Format(timestampOptions().Format.isoLayout()))
+	b.WriteString(" level=")
+	b.WriteString(strings.ToLower(e.Severity))
+	fmt.Fprintf(&b, " caller=%s:%d", e.File, e.Line)
+	if e.Logger != "" {
+		b.WriteString(" logger=")
+		writeLogfmtValue(&b, e.Logger)
+	}
+	b.WriteString(" msg=")
+	b.WriteString(strconv.Quote(strings.TrimSuffix(e.Message, "\n")))
+	if e.Err != nil {
+		b.WriteString(" err=")
+		writeLogfmtValue(&b, e.Err.Error())
+	}
+	for i := 0; i < len(e.KeysAndValues); i += 2 {
+		b.WriteByte(' ')
+		b.WriteString(logfmtKey(fmt.Sprint(e.KeysAndValues[i])))
+		b.WriteByte('=')
+		writeLogfmtValue(&b, logfmtString(kvValue(e.KeysAndValues, i)))
+	}
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+// JSONFormatter formats lines as JSON objects, as in
+//
+//	{"ts":"2020-10-16T12:00:00.000000+02:00","level":"info","caller":"file.go:12","msg":"message","key":"value"}
+type JSONFormatter struct{}
+
+// Format implements Formatter.
+func (JSONFormatter) Format(e *Entry) []byte {
+	var b bytes.Buffer
+	writeJSONField(&b, "ts", e.Time.Format(timestampOptions().Format.isoLayout()))
+	writeJSONField(&b, "level", strings.ToLower(e.Severity))
+	writeJSONField(&b, "caller", fmt.Sprintf("%s:%d", e.File, e.Line))
+	if e.Logger != "" {
+		writeJSONField(&b, "logger", e.Logger)
+	}
+	writeJSONField(&b, "msg", strings.TrimSuffix(e.Message, "\n"))
+	if e.Err != nil {
+		writeJSONField(&b, "err", e.Err.Error())
+	}
+	for i := 0; i < len(e.KeysAndValues); i += 2 {
+		writeJSONField(&b, fmt.Sprint(e.KeysAndValues[i]), kvValue(e.KeysAndValues, i))
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+// writeJSONField writes a field of a JSON object, starting the object if b
+// is empty. Values that cannot be marshaled are written as text.
+func writeJSONField(b *bytes.Buffer, key string, value interface{}) {
+	if b.Len() == 0 {
+		b.WriteByte('{')
+	} else {
+		b.WriteByte(',')
+	}
+	k, _ := json.Marshal(key)
+	b.Write(k)
+	b.WriteByte(':')
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+	v, err := json.Marshal(value)
+	if err != nil {
+		v, _ = json.Marshal(fmt.Sprintf("%+v", value))
+	}
+	b.Write(v)
+}
+
+// logfmtString returns the text of a value.
+func logfmtString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%+v", v)
+}
+
+// logfmtKey replaces the characters that cannot be part of a key.
+func logfmtKey(k string) string {
+	if k == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' || !unicode.IsPrint(r) {
+			return '_'
+		}
+		return r
+	}, k)
+}
+
+// writeLogfmtValue writes v, quoted if needed.
+func writeLogfmtValue(b *bytes.Buffer, v string) {
+	if v == "" || strings.IndexFunc(v, func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == '\\' || r == utf8.RuneError || !unicode.IsPrint(r)
+	}) >= 0 {
+		b.WriteString(strconv.Quote(v))
+		return
+	}
+	b.WriteString(v)
+}
@@ -0,0 +1,183 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	. "k8s.io/klog"
+)
+
+func TestLogfmt(t *testing.T) {
+	_, testCleanup := testSetup(t, "log_format", "logfmt")
+	defer testCleanup()
+	SetClock(ClockFunc(func() time.Time {
+		return time.Date(2020, 10, 16, 12, 0, 0, 123456000, time.UTC)
+	}))
+
+	Info("hello world")
+	_, _, line, _ := runtime.Caller(0)
+	Warningf("a %q\nb", "quoted")
+	InfoS("Pod status updated", LoggerKey, "controller", "pod", "kube-dns", "ready", true, "empty", "", "odd")
+	ErrorS(errors.New("timeout"), "Failed", "a=b", "c d")
+
+	prefix := func(level string, line int) string {
+		return fmt.Sprintf("ts=2020-10-16T12:00:00.123456Z level=%s caller=klog_format_test.go:%d ", level, line)
+	}
+	expected := prefix("info", line-1) + `msg="hello world"` + "\n" +
+		prefix("warning", line+1) + `msg="a \"quoted\"\nb"` + "\n" +
+		prefix("info", line+2) + `logger=controller msg="Pod status updated" pod=kube-dns ready=true empty="" odd=(MISSING)` + "\n" +
+		prefix("error", line+3) + `msg="Failed" err=timeout a_b="c d"` + "\n"
+	if got := contents(infoLog); got != expected {
+		t.Errorf("got\n%s\nexpected\n%s", got, expected)
+	}
+	if got := contents(errorLog); got != prefix("error", line+3)+`msg="Failed" err=timeout a_b="c d"`+"\n" {
+		t.Errorf("unexpected ERROR output %q", got)
+	}
+	if !StructuredOutput() {
+		t.Error("logfmt should be a structured output format")
+	}
+}
+
+func TestLogfmtVerbose(t *testing.T) {
+	_, testCleanup := testSetup(t, "log_format", "logfmt", "v", "1")
+	defer testCleanup()
+
+	V(1).Infof("enabled")
+	V(2).Infof("disabled")
+	FirstN(1).Warning("limited")
+	if got := contents(infoLog); !strings.Contains(got, `level=info caller=klog_format_test.go:`) ||
+		!strings.Contains(got, `msg="enabled"`) || strings.Contains(got, "disabled") || !strings.Contains(got, `msg="limited"`) {
+		t.Errorf("unexpected output %q", got)
+	}
+}
+
+func TestStructuredGlog(t *testing.T) {
+	_, testCleanup := testSetup(t)
+	defer testCleanup()
+
+	InfoS("Pod status updated", "pod", "kube-dns", "count", 1)
+	ErrorS(errors.New("timeout"), "Failed", LoggerKey, "controller")
+	expected := []string{
+		`"Pod status updated" pod="kube-dns" count=1`,
+		`"Failed" err="timeout" logger="controller"`,
+	}
+	if got := messages(contents(infoLog)); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+	if StructuredOutput() {
+		t.Error("glog should not be a structured output format")
+	}
+}
+
+func TestLogFormatInvalid(t *testing.T) {
+	flagset, testCleanup := testSetup(t)
+	defer testCleanup()
+	if err := flagset.Set("log_format", "xml"); err == nil {
+		t.Error("an unknown format should have been rejected")
+	}
+}
+
+func TestLogfmtFatal(t *testing.T) {
+	_, testCleanup := testSetup(t, "log_format", "logfmt", "stderrthreshold", "FATAL")
+	defer testCleanup()
+	SetExitFunc(PanicExit)
+	defer SetExitFunc(nil)
+
+	func() {
+		defer func() {
+			r := recover()
+			if p, ok := r.(ExitPanic); !ok || p.Code != 255 {
+				t.Fatalf("expected Fatal to exit with 255, got %v", r)
+			}
+		}()
+		Fatal("giving up")
+		t.Fatal("Fatal returned")
+	}()
+
+	lines := strings.Split(contents(infoLog), "\n")
+	if !strings.Contains(lines[0], `level=fatal caller=klog_format_test.go:`) || !strings.HasSuffix(lines[0], ` msg="giving up"`) {
+		t.Errorf("unexpected FATAL line %q", lines[0])
+	}
+	if len(lines) < 2 || !strings.HasPrefix(lines[1], "goroutine ") {
+		t.Errorf("expected a stack trace after the FATAL line, got %q", contents(infoLog))
+	}
+}
+
+func TestGlogFormatter(t *testing.T) {
+	_, testCleanup := testSetup(t, "add_dir_header", "true")
+	defer testCleanup()
+	SetClock(ClockFunc(func() time.Time {
+		return time.Date(2020, 10, 16, 12, 0, 0, 123456000, time.Local)
+	}))
+	// Wrapping the default formatter makes the shim format the lines.
+	SetFormatter(FormatterFunc(GlogFormatter{}.Format))
+	defer SetFormatter(nil)
+
+	Info("test")
+	_, _, line, _ := runtime.Caller(0)
+	InfoS("structured", "key", "value")
+	expected := fmt.Sprintf("I1016 12:00:00.123456 %7d klog/klog_format_test.go:%d] test\n", os.Getpid(), line-1) +
+		fmt.Sprintf("I1016 12:00:00.123456 %7d klog/klog_format_test.go:%d] \"structured\" key=\"value\"\n", os.Getpid(), line+1)
+	if got := contents(infoLog); got != expected {
+		t.Errorf("got\n%q\nexpected\n%q", got, expected)
+	}
+}
+
+func TestJSONFormatter(t *testing.T) {
+	_, testCleanup := testSetup(t, "log_format", "json")
+	defer testCleanup()
+	SetClock(ClockFunc(func() time.Time {
+		return time.Date(2020, 10, 16, 12, 0, 0, 123456000, time.UTC)
+	}))
+
+	Infoln("hello", "world")
+	_, _, line, _ := runtime.Caller(0)
+	ErrorS(errors.New("timeout"), "Failed", LoggerKey, "controller", "count", 2, "tags", []string{"a"}, "odd")
+	expected := fmt.Sprintf(`{"ts":"2020-10-16T12:00:00.123456Z","level":"info","caller":"klog_format_test.go:%d","msg":"hello world"}`+"\n", line-1) +
+		fmt.Sprintf(`{"ts":"2020-10-16T12:00:00.123456Z","level":"error","caller":"klog_format_test.go:%d","logger":"controller",`+
+			`"msg":"Failed","err":"timeout","count":2,"tags":["a"],"odd":"(MISSING)"}`+"\n", line+1)
+	if got := contents(infoLog); got != expected {
+		t.Errorf("got\n%s\nexpected\n%s", got, expected)
+	}
+}
+
+func TestCustomFormatter(t *testing.T) {
+	flagset, testCleanup := testSetup(t)
+	defer testCleanup()
+	SetFormatter(FormatterFunc(func(e *Entry) []byte {
+		return []byte(fmt.Sprintf("%s %s:%d %s %v\n", e.Severity, e.File, e.Line, e.Message, e.KeysAndValues))
+	}))
+	defer SetFormatter(nil)
+
+	Warning("custom")
+	_, _, line, _ := runtime.Caller(0)
+	if expected := fmt.Sprintf("WARNING klog_format_test.go:%d custom []\n", line-1); contents(infoLog) != expected {
+		t.Errorf("got %q, expected %q", contents(infoLog), expected)
+	}
+	if got := flagset.Lookup("log_format").Value.String(); got != "" {
+		t.Errorf("got log_format %q for a custom formatter", got)
+	}
+	SetFormatter(nil)
+	if got := flagset.Lookup("log_format").Value.String(); got != "glog" {
+		t.Errorf("got log_format %q after restoring the default", got)
+	}
+}
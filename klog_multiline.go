@@ -0,0 +1,185 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Escaping of multi-line messages.
+
+package klog
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// MultilineMode defines how newlines inside a message are written.
+type MultilineMode string
+
+const (
+	// MultilineRaw writes newlines as they are.
+	MultilineRaw MultilineMode = ""
+	// MultilineEscape writes newlines as \n and carriage returns as \r, so
+	// that each message is on a single line.
+	MultilineEscape MultilineMode = "escape"
+	// MultilineIndent starts each continuation line with IndentMarker, so
+	// that it can be told apart from the header of the next line.
+	MultilineIndent MultilineMode = "indent"
+)
+
+// IndentMarker starts the continuation lines of messages in MultilineIndent
+// mode.
+const IndentMarker = " | "
+
+var multiline struct {
+	mu    sync.Mutex
+	mode  MultilineMode // of INFO, WARNING and ERROR messages
+	fatal MultilineMode // of FATAL messages and stack traces
+}
+
+// SetMultilineMode sets how newlines are written in the messages of the
+// Info, Warning and Error functions and their variants, and thereby of klogr.
+func SetMultilineMode(m MultilineMode) error {
+	if err := m.check(); err != nil {
+		return err
+	}
+	multiline.mu.Lock()
+	defer multiline.mu.Unlock()
+	multiline.mode = m
+	return nil
+}
+
+// SetFatalMultilineMode sets how newlines are written in the messages of the
+// Fatal and Exit functions and their variants, and in the stack traces that
+// follow a Fatal line. Like SetAsyncOutput, it applies to stack traces
+// written to the outputs set with SetOutput or SetOutputBySeverity and to log
+// files, but not to standard error.
+func SetFatalMultilineMode(m MultilineMode) error {
+	if err := m.check(); err != nil {
+		return err
+	}
+	multiline.mu.Lock()
+	multiline.fatal = m
+	multiline.mu.Unlock()
+	if m != MultilineRaw {
+		logFiles.install(false)
+	}
+	installOutputs()
+	return nil
+}
+
+func (m MultilineMode) check() error {
+	switch m {
+	case MultilineRaw, MultilineEscape, MultilineIndent:
+		return nil
+	}
+	return fmt.Errorf("unknown multi-line mode %q", m)
+}
+
+// multilineMode returns the mode of messages with severity s.
+func multilineMode(s severity) MultilineMode {
+	multiline.mu.Lock()
+	defer multiline.mu.Unlock()
+	if s == fatalLog {
+		return multiline.fatal
+	}
+	return multiline.mode
+}
+
+var (
+	escaper  = strings.NewReplacer("\n", `\n`, "\r", `\r`)
+	indenter = strings.NewReplacer("\n", "\n"+IndentMarker)
+)
+
+// apply writes the newlines in text in mode m, except for a final one.
+func (m MultilineMode) apply(text string) string {
+	if m == MultilineRaw {
+		return text
+	}
+	body := strings.TrimSuffix(text, "\n")
+	if !strings.ContainsAny(body, "\r\n") {
+		return text
+	}
+	newline := text[len(body):]
+	if m == MultilineEscape {
+		return escaper.Replace(body) + newline
+	}
+	return indenter.Replace(body) + newline
+}
+
+// traceOutput is the klog v2 output for a severity while stack traces are
+// not written raw. It applies the mode to the lines without header that
+// follow a FATAL line.
+type traceOutput struct {
+	sev severity
+	w   io.Writer
+
+	mu         sync.Mutex
+	afterFatal bool
+}
+
+func (o *traceOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if hasHeader(p) {
+		o.afterFatal = severityOf(p, o.sev) == fatalLog
+		return o.w.Write(p)
+	}
+	if !o.afterFatal {
+		return o.w.Write(p)
+	}
+	if _, err := o.w.Write(formatTrace(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeLine passes the lines of the shim on, as the shim formats the stack
+// traces after its FATAL lines itself.
+func (o *traceOutput) writeLine(p []byte, l lineInfo) (int, error) {
+	o.mu.Lock()
+	o.afterFatal = false
+	o.mu.Unlock()
+	return writeLine(o.w, p, l)
+}
+
+// formatTrace writes the newlines of a stack trace in the mode of FATAL
+// messages.
+func formatTrace(p []byte) []byte {
+	trace := strings.TrimRight(string(p), "\n")
+	switch multilineMode(fatalLog) {
+	case MultilineEscape:
+		return []byte(escaper.Replace(trace) + "\n")
+	case MultilineIndent:
+		return []byte(IndentMarker + indenter.Replace(trace) + "\n")
+	}
+	return p
+}
+
+// multilineValue adapts a multi-line mode setter to the flag.Value
+// interface.
+type multilineValue struct {
+	set func(MultilineMode) error
+	s   severity
+}
+
+func (m multilineValue) String() string {
+	if m.set == nil {
+		return ""
+	}
+	return string(multilineMode(m.s))
+}
+
+func (m multilineValue) Set(value string) error {
+	return m.set(MultilineMode(value))
+}
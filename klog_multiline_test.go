@@ -0,0 +1,105 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog_test
+
+import (
+	"strings"
+	"testing"
+
+	. "k8s.io/klog"
+)
+
+func TestMultilineMode(t *testing.T) {
+	tests := map[string]struct {
+		mode     string
+		expected string
+	}{
+		"raw": {
+			mode:     "",
+			expected: "first\nsecond\r\nthird\n",
+		},
+		"escape": {
+			mode:     "escape",
+			expected: `first\nsecond\r\nthird` + "\n",
+		},
+		"indent": {
+			mode:     "indent",
+			expected: "first\n | second\r\n | third\n",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, testCleanup := testSetup(t, "log_multiline", test.mode, "skip_headers", "true")
+			defer testCleanup()
+
+			Warningln("first\nsecond\r\nthird")
+			if got := contents(infoLog); got != test.expected {
+				t.Errorf("got %q, expected %q", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestMultilineModeInvalid(t *testing.T) {
+	if err := SetMultilineMode("quote"); err == nil {
+		t.Error("SetMultilineMode should have failed for an unknown mode")
+	}
+	if err := SetFatalMultilineMode("quote"); err == nil {
+		t.Error("SetFatalMultilineMode should have failed for an unknown mode")
+	}
+}
+
+func TestFatalMultilineMode(t *testing.T) {
+	for _, mode := range []string{"escape", "indent"} {
+		t.Run(mode, func(t *testing.T) {
+			_, testCleanup := testSetup(t, "log_fatal_multiline", mode, "stderrthreshold", "FATAL")
+			defer testCleanup()
+			SetExitFunc(PanicExit)
+			defer SetExitFunc(nil)
+
+			func() {
+				defer func() {
+					if r := recover(); r == nil {
+						t.Fatal("Fatal returned")
+					} else if _, ok := r.(ExitPanic); !ok {
+						panic(r)
+					}
+				}()
+				Fatal("first\nsecond")
+			}()
+
+			output := contents(infoLog)
+			lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+			if len(lines) < 2 {
+				t.Fatalf("expected a message and a stack trace, got %q", output)
+			}
+			if mode == "escape" {
+				if !strings.HasSuffix(lines[0], `] first\nsecond`) || len(lines) != 2 || !strings.HasPrefix(lines[1], "goroutine ") {
+					t.Errorf("unexpected output %q", output)
+				}
+				return
+			}
+			if !strings.HasSuffix(lines[0], "] first") || lines[1] != " | second" {
+				t.Errorf("unexpected output %q", output)
+			}
+			for _, line := range lines[2:] {
+				if !strings.HasPrefix(line, " | ") {
+					t.Errorf("stack trace line %q is not indented", line)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,401 @@
+// Go support for leveled logs, analogous to https://code.google.com/p/google-glog/
+//
+// Copyright 2013 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Output destinations for logs.
+
+package klog
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	klogv2 "k8s.io/klog/v2"
+)
+
+// outputs holds the output destination of each severity as set through the
+// shim. klog v2 is given a wrapped version of each destination that
+// implements the shim's output features, such as asynchronous output.
+var outputs struct {
+	// mu governs access to dest and installed. klog v2 never holds its own
+	// lock while acquiring mu, so klog v2 may be called with mu held.
+	mu   sync.Mutex
+	dest [numSeverity]io.Writer
+	// installed is the wrapped destination given to klog v2.
+	installed [numSeverity]io.Writer
+	// write serializes the writes to the destinations, which klog v2
+	// makes with its own lock held and the shim with mu held.
+	write sync.Mutex
+}
+
+// setOutput sets the output destination for severity s and installs it as
+// the klog v2 output.
+func setOutput(s severity, w io.Writer) {
+	outputs.mu.Lock()
+	defer outputs.mu.Unlock()
+	if sb, ok := outputs.dest[s].(*syncBuffer); ok && sb != w {
+		logFiles.release(s)
+	}
+	outputs.dest[s] = w
+	installOutput(s)
+}
+
+// output returns the output destination for severity s, or nil if klog v2
+// manages it.
+func output(s severity) io.Writer {
+	outputs.mu.Lock()
+	defer outputs.mu.Unlock()
+	return outputs.dest[s]
+}
+
+// installOutputs re-installs the klog v2 outputs for all severities after the
+// shim's output features have been changed.
+func installOutputs() {
+	outputs.mu.Lock()
+	defer outputs.mu.Unlock()
+	for s := infoLog; s < numSeverity; s++ {
+		if outputs.dest[s] != nil {
+			installOutput(s)
+		}
+	}
+}
+
+// installOutput installs the destination of severity s as the klog v2
+// output, wrapped as needed.
+// outputs.mu is held.
+func installOutput(s severity) {
+	w := outputs.dest[s]
+	if w != nil {
+		w = &statsOutput{sev: s, w: &lockedOutput{w: w}}
+		if async.enabled() {
+			w = &asyncOutput{sev: s, w: w}
+		}
+		if dedupe.enabled() {
+			w = &dedupeOutput{sev: s, w: w}
+		}
+		if multilineMode(fatalLog) != MultilineRaw {
+			w = &traceOutput{sev: s, w: w}
+		}
+	}
+	outputs.installed[s] = w
+	klogv2.SetOutputBySeverity(severityName[s], w)
+}
+
+// lockedOutput writes to a destination with outputs.write held.
+type lockedOutput struct {
+	w io.Writer
+}
+
+func (o *lockedOutput) Write(p []byte) (int, error) {
+	outputs.write.Lock()
+	defer outputs.write.Unlock()
+	return o.w.Write(p)
+}
+
+// lineInfo describes a line that the shim writes to the outputs itself, which
+// the wrappers of the outputs cannot judge by its text like they judge the
+// lines of klog v2 by their glog header.
+type lineInfo struct {
+	sev severity
+	// entry is the entry of the line, or nil for a stack trace.
+	entry *Entry
+}
+
+// A lineWriter is a wrapper of an output that takes the lineInfo of the
+// lines of the shim.
+type lineWriter interface {
+	writeLine(p []byte, l lineInfo) (int, error)
+}
+
+// writeLine writes a line of the shim to w, with l if w is a lineWriter.
+func writeLine(w io.Writer, p []byte, l lineInfo) (int, error) {
+	if lw, ok := w.(lineWriter); ok {
+		return lw.writeLine(p, l)
+	}
+	return w.Write(p)
+}
+
+// severityOf returns the severity of a log line written by klog v2, judging
+// by its header, or def if the line has no header.
+func severityOf(p []byte, def severity) severity {
+	if len(p) < 2 || p[1] < '0' || p[1] > '9' {
+		return def
+	}
+	switch p[0] {
+	case 'I':
+		return infoLog
+	case 'W':
+		return warningLog
+	case 'E':
+		return errorLog
+	case 'F':
+		return fatalLog
+	}
+	return def
+}
+
+// OverflowPolicy decides what the asynchronous output does with a line when
+// its queue is full.
+type OverflowPolicy int
+
+const (
+	// Block makes logging wait until there is room in the queue.
+	Block OverflowPolicy = iota
+	// DropNewest drops the line that is being logged.
+	DropNewest
+	// DropOldest drops the line that has been waiting the longest.
+	DropOldest
+	// DropBelowSeverity drops the line that is being logged if its severity
+	// is below AsyncOptions.DropBelow, and otherwise waits like Block.
+	DropBelowSeverity
+)
+
+// AsyncOptions configures the asynchronous output.
+type AsyncOptions struct {
+	// QueueSize is the maximum number of lines waiting to be written.
+	// Zero disables the asynchronous output.
+	QueueSize int
+	// Overflow decides what happens when the queue is full.
+	Overflow OverflowPolicy
+	// DropBelow is the name of the lowest severity that is not dropped
+	// with DropBelowSeverity, such as "WARNING".
+	DropBelow string
+}
+
+// DroppedStats tracks the number of lines and bytes that the asynchronous
+// output dropped.
+type DroppedStats struct {
+	lines int64
+	bytes int64
+}
+
+// Lines returns the number of lines dropped.
+func (s *DroppedStats) Lines() int64 {
+	return atomic.LoadInt64(&s.lines)
+}
+
+// Bytes returns the number of bytes dropped.
+func (s *DroppedStats) Bytes() int64 {
+	return atomic.LoadInt64(&s.bytes)
+}
+
+// Dropped tracks the number of lines and bytes per output that were dropped
+// by the asynchronous output. A line that is dropped from the ERROR output
+// is usually dropped from the WARNING and INFO outputs as well and is
+// counted for each of them. FATAL lines are never dropped.
+var Dropped struct {
+	Info, Warning, Error DroppedStats
+}
+
+var droppedStats = [numSeverity]*DroppedStats{
+	infoLog:    &Dropped.Info,
+	warningLog: &Dropped.Warning,
+	errorLog:   &Dropped.Error,
+}
+
+// SetAsyncOutput makes the outputs set with SetOutput or SetOutputBySeverity,
+// and the log files, be written to by a background goroutine so that logging
+// does not wait for slow outputs. Lines are queued in order and Flush waits
+// until the queue has been written. FATAL lines are written synchronously,
+// after the queue, because the program exits right after them. Severities
+// without an output get log files managed by the shim.
+//
+// klog v2 writes to standard error itself, so lines that go to standard
+// error because of -logtostderr, -alsologtostderr or -stderrthreshold are
+// always written synchronously. To write standard error asynchronously, use
+// SetOutput(os.Stderr) instead, with -logtostderr=false and a high
+// -stderrthreshold.
+func SetAsyncOutput(opts AsyncOptions) error {
+	if opts.QueueSize < 0 {
+		return fmt.Errorf("invalid queue size %d", opts.QueueSize)
+	}
+	var dropBelow severity
+	if opts.Overflow == DropBelowSeverity {
+		var ok bool
+		if dropBelow, ok = severityByName(opts.DropBelow); !ok {
+			return fmt.Errorf("unrecognized severity name %q", opts.DropBelow)
+		}
+	}
+
+	async.mu.Lock()
+	async.opts = opts
+	async.dropBelow = dropBelow
+	async.fatal = false
+	if async.cond == nil {
+		async.cond = sync.NewCond(&async.mu)
+	}
+	if opts.QueueSize > 0 && !async.running {
+		async.running = true
+		go async.writeLoop()
+	}
+	async.cond.Broadcast() // The queue may have grown.
+	async.mu.Unlock()
+
+	if opts.QueueSize > 0 {
+		logFiles.install(false)
+	}
+	installOutputs()
+	if opts.QueueSize == 0 {
+		async.flush()
+	}
+	return nil
+}
+
+// asyncT is the state of the asynchronous output.
+type asyncT struct {
+	mu        sync.Mutex // governs access to everything below
+	cond      *sync.Cond // signaled whenever the queue changes
+	opts      AsyncOptions
+	dropBelow severity
+	running   bool // whether writeLoop has been started
+	queue     []asyncLine
+	// queued and done count the lines added to and removed from the
+	// queue, which allows flush to wait for the lines queued before it.
+	queued, done uint64
+	// fatal is set from a FATAL line until the program would have exited;
+	// everything in between is written synchronously.
+	fatal bool
+}
+
+var async asyncT
+
+// asyncLine is a line waiting in the queue.
+type asyncLine struct {
+	sev  severity // The severity of the output
+	w    io.Writer
+	data []byte
+}
+
+// enabled reports whether the asynchronous output is enabled.
+func (a *asyncT) enabled() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.opts.QueueSize > 0
+}
+
+// writeLoop writes the queued lines.
+func (a *asyncT) writeLoop() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for {
+		for len(a.queue) == 0 {
+			a.cond.Wait()
+		}
+		line := a.queue[0]
+		a.queue[0] = asyncLine{}
+		a.queue = a.queue[1:]
+		a.mu.Unlock()
+		line.w.Write(line.data) // ignore error
+		a.mu.Lock()
+		a.done++
+		a.cond.Broadcast()
+	}
+}
+
+// flush waits until the lines queued so far have been written.
+func (a *asyncT) flush() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for target := a.queued; a.done < target; {
+		a.cond.Wait()
+	}
+}
+
+// endFatal writes lines asynchronously again after a FATAL line, once the
+// exit function has returned.
+func (a *asyncT) endFatal() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.fatal = false
+}
+
+// drop counts a line as dropped.
+// a.mu is held.
+func (a *asyncT) drop(line asyncLine) {
+	if stats := droppedStats[line.sev]; stats != nil {
+		atomic.AddInt64(&stats.lines, 1)
+		atomic.AddInt64(&stats.bytes, int64(len(line.data)))
+	}
+}
+
+// enqueue adds p, a line of severity lineSev which is being written to o, to
+// the queue, or drops it if the queue is full. It returns false if p must be
+// written synchronously instead.
+// a.mu is held.
+func (a *asyncT) enqueue(o *asyncOutput, p []byte, lineSev severity) bool {
+	if lineSev == fatalLog {
+		a.fatal = true
+	}
+	line := asyncLine{sev: o.sev, w: o.w, data: p}
+	for {
+		if a.opts.QueueSize == 0 || a.fatal {
+			return false
+		}
+		if len(a.queue) < a.opts.QueueSize {
+			break
+		}
+		switch a.opts.Overflow {
+		case DropNewest:
+			a.drop(line)
+			return true
+		case DropOldest:
+			a.drop(a.queue[0])
+			a.queue[0] = asyncLine{}
+			a.queue = a.queue[1:]
+			a.done++
+			continue
+		case DropBelowSeverity:
+			if lineSev < a.dropBelow {
+				a.drop(line)
+				return true
+			}
+		}
+		a.cond.Wait()
+	}
+	// klog v2 reuses p once Write returns.
+	line.data = append([]byte(nil), p...)
+	a.queue = append(a.queue, line)
+	a.queued++
+	a.cond.Broadcast()
+	return true
+}
+
+// asyncOutput is the klog v2 output for a severity while the asynchronous
+// output is enabled.
+type asyncOutput struct {
+	sev severity
+	w   io.Writer
+}
+
+func (o *asyncOutput) Write(p []byte) (int, error) {
+	return o.writeLine(p, lineInfo{sev: severityOf(p, o.sev)})
+}
+
+func (o *asyncOutput) writeLine(p []byte, l lineInfo) (int, error) {
+	a := &async
+	a.mu.Lock()
+	if a.enqueue(o, p, l.sev) {
+		a.mu.Unlock()
+		return len(p), nil
+	}
+	// Keep the order of the lines by writing the queue first.
+	for a.done < a.queued {
+		a.cond.Wait()
+	}
+	a.mu.Unlock()
+	return o.w.Write(p)
+}
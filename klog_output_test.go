@@ -0,0 +1,216 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog_test
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "k8s.io/klog"
+	klogv2 "k8s.io/klog/v2"
+)
+
+// blockingWriter is an output that blocks writes until it is released.
+type blockingWriter struct {
+	started chan struct{} // receives a value when a write starts
+	release chan struct{} // closed to let writes finish
+
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func newBlockingWriter() *blockingWriter {
+	return &blockingWriter{
+		started: make(chan struct{}, 100),
+		release: make(chan struct{}),
+	}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.started <- struct{}{}
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *blockingWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+// messages returns the messages of the lines in the log output.
+func messages(output string) []string {
+	var msgs []string
+	for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+		if i := strings.Index(line, "] "); i >= 0 {
+			msgs = append(msgs, line[i+2:])
+		}
+	}
+	return msgs
+}
+
+// formatMessages returns the messages of the lines in the log output of
+// format, glog or logfmt.
+func formatMessages(format, output string) []string {
+	if format == "glog" {
+		return messages(output)
+	}
+	var msgs []string
+	for _, m := range regexp.MustCompile(`msg="([^"]*)"`).FindAllStringSubmatch(output, -1) {
+		msgs = append(msgs, m[1])
+	}
+	return msgs
+}
+
+func TestAsyncOutput(t *testing.T) {
+	tests := map[string]struct {
+		opts AsyncOptions
+		log  func()
+		// blocks is set if log has to wait for the output.
+		blocks       bool
+		expected     []string
+		droppedInfo  int64
+		droppedError int64
+	}{
+		"block": {
+			opts:     AsyncOptions{QueueSize: 2, Overflow: Block},
+			log:      func() { Info("b"); Info("c"); Info("d") },
+			blocks:   true,
+			expected: []string{"a", "b", "c", "d"},
+		},
+		"drop newest": {
+			opts:        AsyncOptions{QueueSize: 2, Overflow: DropNewest},
+			log:         func() { Info("b"); Info("c"); Info("d") },
+			expected:    []string{"a", "b", "c"},
+			droppedInfo: 1,
+		},
+		"drop oldest": {
+			opts:        AsyncOptions{QueueSize: 2, Overflow: DropOldest},
+			log:         func() { Info("b"); Info("c"); Info("d") },
+			expected:    []string{"a", "c", "d"},
+			droppedInfo: 1,
+		},
+		"drop below severity": {
+			opts:        AsyncOptions{QueueSize: 2, Overflow: DropBelowSeverity, DropBelow: "ERROR"},
+			log:         func() { Info("b"); Info("c"); Info("d"); Error("e") },
+			blocks:      true,
+			expected:    []string{"a", "b", "c", "e"},
+			droppedInfo: 1,
+		},
+	}
+
+	for name, test := range tests {
+		for _, format := range []string{"glog", "logfmt"} {
+			t.Run(format+"/"+name, func(t *testing.T) {
+				_, testCleanup := testSetup(t, "log_format", format)
+				defer testCleanup()
+				w := newBlockingWriter()
+				SetOutputBySeverity("INFO", w)
+				if err := SetAsyncOutput(test.opts); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				defer SetAsyncOutput(AsyncOptions{})
+
+				droppedInfo := Dropped.Info.Lines()
+				droppedError := Dropped.Error.Lines()
+
+				Info("a")
+				<-w.started // "a" is no longer in the queue.
+				done := make(chan struct{})
+				go func() {
+					test.log()
+					close(done)
+				}()
+				if !test.blocks {
+					<-done
+				}
+				close(w.release)
+				<-done
+				Flush()
+
+				if got := formatMessages(format, w.String()); strings.Join(got, ",") != strings.Join(test.expected, ",") {
+					t.Errorf("got %q, expected %q", got, test.expected)
+				}
+				if got := Dropped.Info.Lines() - droppedInfo; got != test.droppedInfo {
+					t.Errorf("got %d dropped INFO lines, expected %d", got, test.droppedInfo)
+				}
+				if got := Dropped.Error.Lines() - droppedError; got != test.droppedError {
+					t.Errorf("got %d dropped ERROR lines, expected %d", got, test.droppedError)
+				}
+			})
+		}
+	}
+}
+
+func TestAsyncOutputAfterFatal(t *testing.T) {
+	_, testCleanup := testSetup(t, "stderrthreshold", "FATAL")
+	defer testCleanup()
+	if err := SetAsyncOutput(AsyncOptions{QueueSize: 2, Overflow: Block}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer SetAsyncOutput(AsyncOptions{})
+	SetExitFunc(func(int) {})
+	defer SetExitFunc(nil)
+
+	Fatal("not exiting")
+	w := newBlockingWriter()
+	SetOutputBySeverity("INFO", w)
+	defer close(w.release)
+	done := make(chan struct{})
+	go func() {
+		Info("queued")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Error("lines are written synchronously after the exit function returned")
+	}
+}
+
+func TestAsyncOutputInvalidOptions(t *testing.T) {
+	if err := SetAsyncOutput(AsyncOptions{QueueSize: 1, Overflow: DropBelowSeverity, DropBelow: "LOG"}); err == nil {
+		t.Error("SetAsyncOutput should have failed for an unknown severity")
+	}
+}
+
+func TestConcurrentShimAndV2Lines(t *testing.T) {
+	_, testCleanup := testSetup(t, "log_format", "logfmt")
+	defer testCleanup()
+
+	// klog v2 writes its lines while the shim writes the logfmt lines, to
+	// the same output, which does not synchronize writes itself.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			klogv2.Info("v2")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		Info("shim")
+	}
+	wg.Wait()
+
+	if got := strings.Count(contents(infoLog), "\n"); got != 200 {
+		t.Errorf("got %d lines, expected 200", got)
+	}
+}
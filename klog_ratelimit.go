@@ -0,0 +1,230 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Rate limiting per call site.
+
+package klog
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// summaryInterval is how often a call site that keeps being suppressed
+// reports the number of suppressed lines.
+const summaryInterval = time.Minute
+
+// rateLimits holds the state of each rate limited call site.
+var rateLimits struct {
+	mu    sync.Mutex
+	sites map[uintptr]*rateSite // keyed by the pc of the call site
+}
+
+// rateSite is a token bucket for one call site.
+type rateSite struct {
+	tokens      int
+	refilled    time.Time // when tokens were last refilled
+	suppressed  int       // lines suppressed since the last summary
+	lastSummary time.Time
+}
+
+// RateLimited is a boolean-like type returned by Every, FirstN and Burst
+// that implements Info, Warning and Error and their variants. These methods
+// write to the log only if the call site was not over its limit. When lines
+// have been suppressed, a line saying how many precedes the next line that is
+// written, or is written on its own once a minute while the call site stays
+// over its limit.
+type RateLimited struct {
+	allowed    bool
+	suppressed int // the number of suppressed lines to report
+}
+
+// Every limits the calling site to one line per d. Use as
+//
+//	klog.Every(time.Minute).Errorf("watch failed: %v", err)
+func Every(d time.Duration) RateLimited {
+	return rateLimit(1, d)
+}
+
+// FirstN limits the calling site to its first n lines.
+func FirstN(n int) RateLimited {
+	return rateLimit(n, 0)
+}
+
+// Burst limits the calling site to bursts of up to n lines, with the budget
+// being refilled by one line every d.
+func Burst(n int, d time.Duration) RateLimited {
+	return rateLimit(n, d)
+}
+
+// rateLimit applies a token bucket with burst tokens that is refilled by one
+// token every refill, or never if refill is zero, to the caller's caller.
+func rateLimit(burst int, refill time.Duration) RateLimited {
+	var pcs [1]uintptr
+	if runtime.Callers(3, pcs[:]) == 0 {
+		return RateLimited{allowed: true}
+	}
+	now := timeNow()
+
+	rateLimits.mu.Lock()
+	defer rateLimits.mu.Unlock()
+	if rateLimits.sites == nil {
+		rateLimits.sites = make(map[uintptr]*rateSite)
+	}
+	site, ok := rateLimits.sites[pcs[0]]
+	if !ok {
+		site = &rateSite{tokens: burst, refilled: now, lastSummary: now}
+		rateLimits.sites[pcs[0]] = site
+	}
+
+	if site.tokens >= burst {
+		// A full bucket starts refilling only once a token is taken.
+		site.refilled = now
+	} else if refill > 0 {
+		if n := int(now.Sub(site.refilled) / refill); n > 0 {
+			site.tokens += n
+			if site.tokens > burst {
+				site.tokens = burst
+			}
+			site.refilled = site.refilled.Add(time.Duration(n) * refill)
+		}
+	}
+
+	r := RateLimited{allowed: site.tokens > 0}
+	if site.suppressed > 0 && (r.allowed || now.Sub(site.lastSummary) >= summaryInterval) {
+		r.suppressed = site.suppressed
+		site.suppressed = 0
+		site.lastSummary = now
+	}
+	if r.allowed {
+		site.tokens--
+	} else {
+		site.suppressed++
+	}
+	return r
+}
+
+// summary returns the line reporting the suppressed lines.
+func (r RateLimited) summary() string {
+	return fmt.Sprintf("suppressed %d messages", r.suppressed)
+}
+
+// countSuppressed counts a suppressed line of severity s.
+func (r RateLimited) countSuppressed(s severity) {
+	if !r.allowed {
+		atomic.AddInt64(&ExtendedStats.suppressed[s], 1)
+	}
+}
+
+// Info is equivalent to the global Info function, guarded by the rate limit.
+func (r RateLimited) Info(args ...interface{}) {
+	r.countSuppressed(infoLog)
+	if r.suppressed > 0 {
+		printDepth(infoLog, 1, r.summary())
+	}
+	if r.allowed {
+		printDepth(infoLog, 1, fmt.Sprint(args...))
+	}
+}
+
+// Infoln is equivalent to the global Infoln function, guarded by the rate limit.
+func (r RateLimited) Infoln(args ...interface{}) {
+	r.countSuppressed(infoLog)
+	if r.suppressed > 0 {
+		printDepth(infoLog, 1, r.summary())
+	}
+	if r.allowed {
+		printDepth(infoLog, 1, fmt.Sprintln(args...))
+	}
+}
+
+// Infof is equivalent to the global Infof function, guarded by the rate limit.
+func (r RateLimited) Infof(format string, args ...interface{}) {
+	r.countSuppressed(infoLog)
+	if r.suppressed > 0 {
+		printDepth(infoLog, 1, r.summary())
+	}
+	if r.allowed {
+		printDepth(infoLog, 1, fmt.Sprintf(format, args...))
+	}
+}
+
+// Warning is equivalent to the global Warning function, guarded by the rate limit.
+func (r RateLimited) Warning(args ...interface{}) {
+	r.countSuppressed(warningLog)
+	if r.suppressed > 0 {
+		printDepth(warningLog, 1, r.summary())
+	}
+	if r.allowed {
+		printDepth(warningLog, 1, fmt.Sprint(args...))
+	}
+}
+
+// Warningln is equivalent to the global Warningln function, guarded by the rate limit.
+func (r RateLimited) Warningln(args ...interface{}) {
+	r.countSuppressed(warningLog)
+	if r.suppressed > 0 {
+		printDepth(warningLog, 1, r.summary())
+	}
+	if r.allowed {
+		printDepth(warningLog, 1, fmt.Sprintln(args...))
+	}
+}
+
+// Warningf is equivalent to the global Warningf function, guarded by the rate limit.
+func (r RateLimited) Warningf(format string, args ...interface{}) {
+	r.countSuppressed(warningLog)
+	if r.suppressed > 0 {
+		printDepth(warningLog, 1, r.summary())
+	}
+	if r.allowed {
+		printDepth(warningLog, 1, fmt.Sprintf(format, args...))
+	}
+}
+
+// Error is equivalent to the global Error function, guarded by the rate limit.
+func (r RateLimited) Error(args ...interface{}) {
+	r.countSuppressed(errorLog)
+	if r.suppressed > 0 {
+		printDepth(errorLog, 1, r.summary())
+	}
+	if r.allowed {
+		printDepth(errorLog, 1, fmt.Sprint(args...))
+	}
+}
+
+// Errorln is equivalent to the global Errorln function, guarded by the rate limit.
+func (r RateLimited) Errorln(args ...interface{}) {
+	r.countSuppressed(errorLog)
+	if r.suppressed > 0 {
+		printDepth(errorLog, 1, r.summary())
+	}
+	if r.allowed {
+		printDepth(errorLog, 1, fmt.Sprintln(args...))
+	}
+}
+
+// Errorf is equivalent to the global Errorf function, guarded by the rate limit.
+func (r RateLimited) Errorf(format string, args ...interface{}) {
+	r.countSuppressed(errorLog)
+	if r.suppressed > 0 {
+		printDepth(errorLog, 1, r.summary())
+	}
+	if r.allowed {
+		printDepth(errorLog, 1, fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	. "k8s.io/klog"
+)
+
+func TestRateLimit(t *testing.T) {
+	tests := map[string]struct {
+		// log is called once per second, with the number of the call.
+		log      func(i int)
+		calls    int
+		expected []string
+	}{
+		"every": {
+			log:   func(i int) { Every(3*time.Second).Infof("line %d", i) },
+			calls: 7,
+			expected: []string{
+				"line 0",
+				"suppressed 2 messages", "line 3",
+				"suppressed 2 messages", "line 6",
+			},
+		},
+		"first n": {
+			log:      func(i int) { FirstN(2).Warningf("line %d", i) },
+			calls:    5,
+			expected: []string{"line 0", "line 1"},
+		},
+		"first n summary": {
+			log:   func(i int) { FirstN(1).Errorf("line %d", i) },
+			calls: 62,
+			expected: []string{
+				"line 0",
+				"suppressed 59 messages",
+			},
+		},
+		"burst": {
+			log:   func(i int) { Burst(2, 2*time.Second).Infof("line %d", i) },
+			calls: 6,
+			expected: []string{
+				"line 0", "line 1", "line 2",
+				"suppressed 1 messages", "line 4",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, testCleanup := testSetup(t)
+			defer testCleanup()
+			now := time.Date(2020, time.July, 18, 10, 0, 0, 0, time.Local)
+			SetClock(ClockFunc(func() time.Time { return now }))
+
+			for i := 0; i < test.calls; i++ {
+				test.log(i)
+				now = now.Add(time.Second)
+			}
+			if got := messages(contents(infoLog)); strings.Join(got, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("got %q, expected %q", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestRateLimitPerCallSite(t *testing.T) {
+	_, testCleanup := testSetup(t)
+	defer testCleanup()
+
+	for i := 0; i < 2; i++ {
+		FirstN(1).Info("a")
+		FirstN(1).Info("b")
+	}
+	if got := fmt.Sprint(messages(contents(infoLog))); got != "[a b]" {
+		t.Errorf("got %s, expected [a b]", got)
+	}
+}
+
+func TestRateLimitStats(t *testing.T) {
+	_, testCleanup := testSetup(t)
+	defer testCleanup()
+
+	before := ExtendedStats.Snapshot()
+	for i := 0; i < 3; i++ {
+		FirstN(1).Warning("x")
+	}
+	after := ExtendedStats.Snapshot()
+	if got := after.Warning.SuppressedLines - before.Warning.SuppressedLines; got != 2 {
+		t.Errorf("got %d suppressed lines, expected 2", got)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Redaction of secrets in log messages.
+
+package klog
+
+import (
+	"regexp"
+	"sync"
+)
+
+// Redacted is what redactors replace secrets with.
+const Redacted = "[REDACTED]"
+
+// A Redactor removes secrets from log messages. Redact is called with the
+// formatted message of every line logged through this package, before the
+// header is added, and returns the message to log instead. For the lines of
+// InfoS, ErrorS and klogr that the shim formats or passes to sinks, it is
+// also called with the error and with each string value.
+type Redactor interface {
+	Redact(msg string) string
+}
+
+// The RedactorFunc type is an adapter to allow the use of ordinary functions
+// as redactors.
+type RedactorFunc func(msg string) string
+
+// Redact calls f(msg).
+func (f RedactorFunc) Redact(msg string) string {
+	return f(msg)
+}
+
+// regexpRedactor replaces the matches of a regular expression.
+type regexpRedactor struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// RegexpRedactor returns a Redactor that replaces the matches of re with
+// replacement, in which $1 and the like are expanded as in
+// regexp.Regexp.ReplaceAllString.
+func RegexpRedactor(re *regexp.Regexp, replacement string) Redactor {
+	return regexpRedactor{re: re, replacement: replacement}
+}
+
+func (r regexpRedactor) Redact(msg string) string {
+	return r.re.ReplaceAllString(msg, r.replacement)
+}
+
+// DefaultRedactors are rules for common secrets: the credentials of
+// Authorization headers and bearer tokens, strings that look like JSON Web
+// Tokens, and the values of password=... and password: ... pairs. They are
+// not enabled by default; use
+//
+//	klog.SetRedactors(klog.DefaultRedactors...)
+var DefaultRedactors = []Redactor{
+	RegexpRedactor(regexp.MustCompile(`(?i)(\bauthorization["']?\s*[:=]\s*\[?["']?(?:(?:bearer|basic|digest|token)\s+)?)[^\s"',\]}]+`), "${1}"+Redacted),
+	RegexpRedactor(regexp.MustCompile(`(?i)(\bbearer\s+)[A-Za-z0-9\-._~+/]+=*`), "${1}"+Redacted),
+	RegexpRedactor(regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), Redacted),
+	RegexpRedactor(regexp.MustCompile(`(?i)(\b(?:password|passwd|pwd)["']?\s*[:=]\s*)("[^"]*"|[^\s"&,;]+)`), "${1}"+Redacted),
+}
+
+var redactors struct {
+	mu sync.RWMutex
+	r  []Redactor
+}
+
+// SetRedactors sets the redactors that are applied, in order, to the
+// messages logged by the Info, Warning, Error, Fatal and Exit functions and
+// their variants, and thereby by klogr. SetRedactors() disables redaction.
+// Messages that klog v2 logs itself are not redacted.
+func SetRedactors(r ...Redactor) {
+	redactors.mu.Lock()
+	defer redactors.mu.Unlock()
+	redactors.r = append([]Redactor(nil), r...)
+}
+
+// redact applies the redactors to msg.
+func redact(msg string) string {
+	redactors.mu.RLock()
+	defer redactors.mu.RUnlock()
+	for _, r := range redactors.r {
+		msg = r.Redact(msg)
+	}
+	return msg
+}
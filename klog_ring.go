@@ -0,0 +1,182 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// In-memory ring buffer of recent log lines.
+
+package klog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// RecentLine is a line kept by the ring buffer.
+type RecentLine struct {
+	Entry
+	// Suppressed is set for the lines of V calls whose level was not
+	// enabled, and Level is their V level.
+	Suppressed bool
+	Level      Level
+}
+
+// ringT is the ring buffer of recent lines.
+type ringT struct {
+	// recordLevel is maxLevel while V lines that are not enabled are
+	// recorded, and 0 otherwise.
+	recordLevel int32
+
+	mu       sync.Mutex // governs access to everything below
+	lines    []RecentLine
+	next     int // index of the oldest line once lines is full
+	size     int
+	maxLevel Level
+	// levels holds the level of V calls that were not enabled, by the
+	// file:line of the call site, for the methods of Verbose.
+	levels map[string]Level
+}
+
+var ring ringT
+
+// ringSink adds the lines that are logged to the ring buffer.
+type ringSink struct{}
+
+// SetRingBuffer keeps the last size lines in memory, including the lines of
+// calls to V up to maxLevel whose level is not enabled and which are not
+// logged otherwise, so that RecentLines and WriteRecentLines can show what
+// happened before a crash in detail. The lines are written to standard error
+// when a FATAL line is logged. A size of zero disables the ring buffer.
+//
+// Suppressed V lines are recorded for the form
+//
+//	klog.V(6).Infof("...")
+//
+// with V and its method called on the same line. Recording them costs a
+// runtime.Caller and a lock in each call to V up to maxLevel that is not
+// enabled, so they are only recorded with a maxLevel above zero; with zero,
+// V costs nothing more. Like Sink, the ring buffer does not see the lines
+// that klog v2 logs itself.
+func SetRingBuffer(size int, maxLevel Level) {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	enabled := ring.size > 0
+	ring.lines, ring.next, ring.size, ring.maxLevel = nil, 0, size, maxLevel
+	ring.levels = make(map[string]Level)
+	if size > 0 && maxLevel > 0 {
+		atomic.StoreInt32(&ring.recordLevel, int32(maxLevel))
+	} else {
+		atomic.StoreInt32(&ring.recordLevel, 0)
+	}
+	switch {
+	case size > 0 && !enabled:
+		AddSink(ringSink{})
+	case size <= 0 && enabled:
+		RemoveSink(ringSink{})
+	}
+}
+
+// RecentLines returns the lines in the ring buffer, oldest first.
+func RecentLines() []RecentLine {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	lines := make([]RecentLine, 0, len(ring.lines))
+	lines = append(lines, ring.lines[ring.next:]...)
+	return append(lines, ring.lines[:ring.next]...)
+}
+
+// WriteRecentLines writes the lines in the ring buffer to w, oldest first,
+// in the output format.
+func WriteRecentLines(w io.Writer) error {
+	f := currentFormatter()
+	if f == nil {
+		f = GlogFormatter{}
+	}
+	for _, line := range RecentLines() {
+		if _, err := w.Write(f.Format(&line.Entry)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add adds a line, replacing the oldest line when the ring buffer is full.
+func (r *ringT) add(line RecentLine) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case r.size <= 0:
+	case len(r.lines) < r.size:
+		r.lines = append(r.lines, line)
+	default:
+		r.lines[r.next] = line
+		r.next = (r.next + 1) % r.size
+	}
+}
+
+// recordingSuppressed reports whether V lines that are not enabled may be
+// recorded.
+func (r *ringT) recordingSuppressed() bool {
+	return atomic.LoadInt32(&r.recordLevel) != 0
+}
+
+// noteSuppressed remembers the level of a V call that was not enabled, for
+// the call frame depth frames above the caller of noteSuppressed.
+func (r *ringT) noteSuppressed(depth int, level Level) {
+	if max := atomic.LoadInt32(&r.recordLevel); max == 0 || int32(level) > max {
+		return
+	}
+	_, file, line, ok := runtime.Caller(depth + 1)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if level <= r.maxLevel {
+		r.levels[file+":"+strconv.Itoa(line)] = level
+	}
+}
+
+// addSuppressed records msg, logged with a Verbose that is false by the
+// call frame depth frames above the caller of addSuppressed, if its V call
+// was noted.
+func (r *ringT) addSuppressed(depth int, msg string) {
+	_, file, line, ok := runtime.Caller(depth + 1)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	level, ok := r.levels[file+":"+strconv.Itoa(line)]
+	r.mu.Unlock()
+	if !ok {
+		return
+	}
+	e := &Entry{Message: prepare(infoLog, msg)}
+	fillEntry(infoLog, depth+1, e)
+	r.add(RecentLine{Entry: *e, Suppressed: true, Level: level})
+}
+
+func (ringSink) Log(e *Entry) {
+	line := RecentLine{Entry: *e}
+	// The entry must not be retained.
+	line.KeysAndValues = append([]interface{}(nil), e.KeysAndValues...)
+	ring.add(line)
+	if e.Severity == severityName[fatalLog] {
+		fmt.Fprintln(os.Stderr, "klog: recent log lines:")
+		WriteRecentLines(os.Stderr) // ignore error
+	}
+}
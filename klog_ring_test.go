@@ -0,0 +1,117 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	. "k8s.io/klog"
+)
+
+func TestRingBuffer(t *testing.T) {
+	_, testCleanup := testSetup(t, "v", "1")
+	defer testCleanup()
+	SetRingBuffer(4, 3)
+	defer SetRingBuffer(0, 0)
+
+	Info("first")
+	V(1).Info("enabled")
+	V(2).Infof("suppressed %d", 2)
+	V(4).Info("above the maximum level")
+	if V(3) {
+		Info("not called")
+	}
+	Warning("second")
+	V(3).Infoln("suppressed", 3)
+
+	var texts []string
+	for _, line := range RecentLines() {
+		texts = append(texts, line.Severity+" "+strings.TrimSuffix(line.Message, "\n"))
+		if line.Suppressed && (line.Level < 2 || line.Level > 3) {
+			t.Errorf("unexpected level of %+v", line)
+		}
+	}
+	expected := []string{"INFO enabled", "INFO suppressed 2", "WARNING second", "INFO suppressed 3"}
+	if strings.Join(texts, ",") != strings.Join(expected, ",") {
+		t.Errorf("got %q, expected %q", texts, expected)
+	}
+	// Only the lines that were logged are in the output.
+	if got := messages(contents(infoLog)); strings.Join(got, ",") != "first,enabled,second" {
+		t.Errorf("unexpected output %q", got)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteRecentLines(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := messages(buf.String()); strings.Join(got, ",") != "enabled,suppressed 2,second,suppressed 3" {
+		t.Errorf("unexpected recent lines %q", got)
+	}
+
+	SetRingBuffer(0, 0)
+	Info("disabled")
+	if lines := RecentLines(); len(lines) != 0 {
+		t.Errorf("expected no lines, got %+v", lines)
+	}
+}
+
+func TestRingBufferKeysAndValues(t *testing.T) {
+	_, testCleanup := testSetup(t)
+	defer testCleanup()
+	SetRingBuffer(4, 0)
+	defer SetRingBuffer(0, 0)
+
+	kvs := []interface{}{"pod", "kubedns"}
+	InfoS("Pod status updated", kvs...)
+	kvs[1] = "changed"
+
+	lines := RecentLines()
+	if len(lines) != 1 || lines[0].KeysAndValues[1] != "kubedns" {
+		t.Errorf("the ring buffer should keep a copy of the key/value pairs, got %+v", lines)
+	}
+}
+
+func TestRingBufferFatal(t *testing.T) {
+	_, testCleanup := testSetup(t, "stderrthreshold", "FATAL")
+	defer testCleanup()
+	SetRingBuffer(10, 6)
+	defer SetRingBuffer(0, 0)
+	SetExitFunc(PanicExit)
+	defer SetExitFunc(nil)
+
+	V(6).Info("debug details")
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatal("Fatal returned")
+			} else if _, ok := r.(ExitPanic); !ok {
+				panic(r)
+			}
+		}()
+		Fatal("crashing")
+	}()
+
+	got := stderrContents()
+	i := strings.Index(got, "klog: recent log lines:\n")
+	if i < 0 {
+		t.Fatalf("no recent lines in %q", got)
+	}
+	lines := strings.Split(got[i:], "\n")
+	if len(lines) < 4 || !strings.HasSuffix(lines[1], "] debug details") || !strings.HasSuffix(lines[2], "] crashing") {
+		t.Errorf("unexpected output %q", got)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Sampling of V-level logging per call site.
+
+package klog
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// samples holds the number of enabled calls of each sampled call site.
+var samples struct {
+	mu    sync.Mutex
+	calls map[uintptr]uint64 // keyed by the pc of the call site
+}
+
+// Sampled is a boolean-like type returned by Verbose.Sample and
+// Verbose.SampleProbability that implements Info, Infoln and Infof. These
+// methods write to the Info log only for the sampled calls, and add the
+// sampling rate to the line, as in "message (sampled 1 in 100)".
+type Sampled struct {
+	sampled bool
+	rate    string
+}
+
+// Sample logs one in n of the calls from the calling site for which v is
+// true, starting with the first one. Use as
+//
+//	klog.V(5).Sample(100).Infof("processing %v", item)
+//
+// Which calls are sampled depends only on the number of calls from the
+// site, so the output is reproducible.
+func (v Verbose) Sample(n int) Sampled {
+	if !v {
+		return Sampled{}
+	}
+	if n <= 1 {
+		return Sampled{sampled: true}
+	}
+	k := sampleCall()
+	return Sampled{
+		sampled: k%uint64(n) == 0,
+		rate:    fmt.Sprintf("sampled 1 in %d", n),
+	}
+}
+
+// SampleProbability logs a fraction p of the calls from the calling site for
+// which v is true, starting with the first one. Like Sample, it does so
+// deterministically: it logs the calls that make the number of sampled calls
+// reach the next integer below p times the number of calls.
+func (v Verbose) SampleProbability(p float64) Sampled {
+	if !v || p <= 0 {
+		return Sampled{}
+	}
+	if p >= 1 {
+		return Sampled{sampled: true}
+	}
+	k := sampleCall()
+	return Sampled{
+		sampled: k == 0 || uint64(float64(k)*p) > uint64(float64(k-1)*p),
+		rate:    "sampled with probability " + strconv.FormatFloat(p, 'g', -1, 64),
+	}
+}
+
+// sampleCall counts a call from the caller's caller, returning the number of
+// calls from that site before it.
+func sampleCall() uint64 {
+	var pcs [1]uintptr
+	if runtime.Callers(3, pcs[:]) == 0 {
+		return 0
+	}
+	samples.mu.Lock()
+	defer samples.mu.Unlock()
+	if samples.calls == nil {
+		samples.calls = make(map[uintptr]uint64)
+	}
+	k := samples.calls[pcs[0]]
+	samples.calls[pcs[0]] = k + 1
+	return k
+}
+
+// withRate adds the sampling rate to msg.
+func (s Sampled) withRate(msg string) string {
+	if s.rate == "" {
+		return msg
+	}
+	if strings.HasSuffix(msg, "\n") {
+		return msg[:len(msg)-1] + " (" + s.rate + ")\n"
+	}
+	return msg + " (" + s.rate + ")"
+}
+
+// Info is equivalent to the global Info function, guarded by the sampling.
+func (s Sampled) Info(args ...interface{}) {
+	if s.sampled {
+		ExtendedStats.countVLine()
+		printDepth(infoLog, 1, s.withRate(fmt.Sprint(args...)))
+	}
+}
+
+// Infoln is equivalent to the global Infoln function, guarded by the sampling.
+func (s Sampled) Infoln(args ...interface{}) {
+	if s.sampled {
+		ExtendedStats.countVLine()
+		printDepth(infoLog, 1, s.withRate(fmt.Sprintln(args...)))
+	}
+}
+
+// Infof is equivalent to the global Infof function, guarded by the sampling.
+func (s Sampled) Infof(format string, args ...interface{}) {
+	if s.sampled {
+		ExtendedStats.countVLine()
+		printDepth(infoLog, 1, s.withRate(fmt.Sprintf(format, args...)))
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog_test
+
+import (
+	"strings"
+	"testing"
+
+	. "k8s.io/klog"
+)
+
+func TestSample(t *testing.T) {
+	tests := map[string]struct {
+		log      func(i int)
+		expected []string
+	}{
+		"one in n": {
+			log: func(i int) { V(1).Sample(3).Infof("line %d", i) },
+			expected: []string{
+				"line 0 (sampled 1 in 3)",
+				"line 3 (sampled 1 in 3)",
+				"line 6 (sampled 1 in 3)",
+			},
+		},
+		"probability": {
+			log: func(i int) { V(1).SampleProbability(0.25).Info("line ", i) },
+			expected: []string{
+				"line 0 (sampled with probability 0.25)",
+				"line 4 (sampled with probability 0.25)",
+				"line 8 (sampled with probability 0.25)",
+			},
+		},
+		"infoln": {
+			log:      func(i int) { V(1).Sample(5).Infoln("line", i) },
+			expected: []string{"line 0 (sampled 1 in 5)", "line 5 (sampled 1 in 5)"},
+		},
+		"not sampled": {
+			log:      func(i int) { V(1).Sample(1).Info("line ", i) },
+			expected: []string{"line 0", "line 1", "line 2", "line 3", "line 4", "line 5", "line 6", "line 7", "line 8"},
+		},
+		"disabled": {
+			log: func(i int) { V(2).Sample(3).Info("line ", i) },
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, testCleanup := testSetup(t, "v", "1")
+			defer testCleanup()
+			for i := 0; i < 9; i++ {
+				test.log(i)
+			}
+			if got := messages(contents(infoLog)); strings.Join(got, ",") != strings.Join(test.expected, ",") {
+				t.Errorf("got %q, expected %q", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestSamplePerCallSite(t *testing.T) {
+	_, testCleanup := testSetup(t, "v", "1")
+	defer testCleanup()
+	for i := 0; i < 4; i++ {
+		V(1).Sample(2).Info("a", i)
+		V(1).Sample(2).Info("b", i)
+	}
+	expected := []string{"a0 (sampled 1 in 2)", "b0 (sampled 1 in 2)", "a2 (sampled 1 in 2)", "b2 (sampled 1 in 2)"}
+	if got := messages(contents(infoLog)); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
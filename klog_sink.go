@@ -0,0 +1,86 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Sinks that receive log lines in addition to the outputs.
+
+package klog
+
+import (
+	"fmt"
+	"sync"
+)
+
+// A Sink receives the log lines of this package and of klogr in addition to
+// the outputs, before they are written. Log is called for all severities,
+// including a FATAL line before the program exits, from the goroutine that
+// logs the line; it must not log through klog itself. The Entry must not be
+// retained after Log returns.
+type Sink interface {
+	Log(e *Entry)
+}
+
+var sinks struct {
+	mu sync.RWMutex
+	s  []Sink
+}
+
+// AddSink adds a sink. Lines that klog v2 logs itself are not passed to
+// sinks.
+func AddSink(s Sink) {
+	sinks.mu.Lock()
+	defer sinks.mu.Unlock()
+	sinks.s = append(sinks.s, s)
+}
+
+// RemoveSink removes a sink added with AddSink.
+func RemoveSink(s Sink) {
+	sinks.mu.Lock()
+	defer sinks.mu.Unlock()
+	for i, t := range sinks.s {
+		if t == s {
+			sinks.s = append(sinks.s[:i:i], sinks.s[i+1:]...)
+			return
+		}
+	}
+}
+
+// currentSinks returns the sinks.
+func currentSinks() []Sink {
+	sinks.mu.RLock()
+	defer sinks.mu.RUnlock()
+	return sinks.s
+}
+
+// LogEntryDepth logs e for the call frame depth frames above its caller,
+// with the severity e.Severity, "INFO", "WARNING" or "ERROR", and fills in
+// its time and caller. It is for adapters such as klogr that write lines in a
+// format of their own: unless e.Structured is set, the line is e.Message, as
+// with InfoDepth, while the sinks also get e.Logger, e.Err and
+// e.KeysAndValues.
+func LogEntryDepth(depth int, e *Entry) {
+	s, ok := severityByName(e.Severity)
+	if !ok || s == fatalLog {
+		panic(fmt.Sprintf("LogEntryDepth: unsupported severity %q", e.Severity))
+	}
+	logDepth(s, depth+1, e, false)
+}
+
+// PrepareEntry applies the redaction, truncation and multi-line mode that
+// klog applies to the lines it logs to e, for adapters that pass entries to
+// sinks themselves. Entries with an unknown severity are left alone.
+func PrepareEntry(e *Entry) {
+	if s, ok := severityByName(e.Severity); ok {
+		prepareEntry(s, e)
+	}
+}
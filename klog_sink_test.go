@@ -0,0 +1,84 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog_test
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	. "k8s.io/klog"
+)
+
+// entries is a Sink that records its entries.
+type entries []Entry
+
+func (s *entries) Log(e *Entry) {
+	*s = append(*s, *e)
+}
+
+func TestSink(t *testing.T) {
+	_, testCleanup := testSetup(t)
+	defer testCleanup()
+
+	s := new(entries)
+	AddSink(s)
+	SetRedactors(RegexpRedactor(regexp.MustCompile(`secret`), Redacted))
+	Info("hello secret")
+	ErrorS(errors.New("timeout"), "sync failed", "pod", "kubedns")
+	RemoveSink(s)
+	SetRedactors()
+	Info("not passed")
+
+	if len(*s) != 2 {
+		t.Fatalf("got %d entries, want 2: %+v", len(*s), *s)
+	}
+	e := (*s)[0]
+	if e.Severity != "INFO" || e.Message != "hello [REDACTED]" || e.File != "klog_sink_test.go" || e.Line == 0 || e.Time.IsZero() {
+		t.Errorf("wrong entry: %+v", e)
+	}
+	if e := (*s)[1]; e.Severity != "ERROR" || e.Text() != `"sync failed" err="timeout" pod="kubedns"` {
+		t.Errorf("wrong structured entry: %+v", e)
+	}
+	// The outputs are not affected by the sink.
+	expected := []string{"hello [REDACTED]", `"sync failed" err="timeout" pod="kubedns"`, "not passed"}
+	if got := messages(contents(infoLog)); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("wrong output: %q", got)
+	}
+}
+
+func TestLogEntryDepth(t *testing.T) {
+	_, testCleanup := testSetup(t)
+	defer testCleanup()
+
+	s := new(entries)
+	AddSink(s)
+	defer RemoveSink(s)
+	LogEntryDepth(0, &Entry{Severity: "WARNING", Message: "pod kubedns: sync failed", KeysAndValues: []interface{}{"pod", "kubedns"}})
+
+	if got := messages(contents(warningLog)); len(got) != 1 || got[0] != "pod kubedns: sync failed" {
+		t.Errorf("unexpected output %q", got)
+	}
+	if len(*s) != 1 || (*s)[0].File != "klog_sink_test.go" || len((*s)[0].KeysAndValues) != 2 {
+		t.Errorf("unexpected entries %+v", *s)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("LogEntryDepth should panic for FATAL")
+		}
+	}()
+	LogEntryDepth(0, &Entry{Severity: "FATAL"})
+}
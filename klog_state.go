@@ -0,0 +1,99 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Saving and restoring the settings, for tests.
+
+package klog
+
+import (
+	"io"
+)
+
+// stateFlags are the klog v2 flags whose values a State holds.
+var stateFlags = []string{"v", "vmodule", "stderrthreshold", "log_backtrace_at"}
+
+// State is a snapshot of the settings that tests usually change, taken by
+// CaptureState.
+type State struct {
+	flags         map[string]string
+	vModuleFilter []modulePat
+	vModuleCache  map[uintptr]Level
+	outputs       [numSeverity]io.Writer
+}
+
+// CaptureState returns the current verbosity, -vmodule, stderr threshold,
+// -log_backtrace_at and the outputs set with SetOutput or
+// SetOutputBySeverity, so that a test can change them and undo its changes
+// with Restore:
+//
+//	defer klog.CaptureState().Restore()
+//	klog.SetOutput(&buf)
+func CaptureState() *State {
+	state := &State{flags: make(map[string]string)}
+	for _, name := range stateFlags {
+		state.flags[name] = v2flag(name)
+	}
+	if state.flags["log_backtrace_at"] == ":0" {
+		// klog v2 shows an unset -log_backtrace_at as ":0", which it
+		// does not accept as a value.
+		state.flags["log_backtrace_at"] = ""
+	}
+
+	global.vModuleMu.Lock()
+	state.vModuleFilter = append([]modulePat(nil), global.vModuleFilter...)
+	state.vModuleCache = make(map[uintptr]Level, len(global.vModuleCache))
+	for pc, level := range global.vModuleCache {
+		state.vModuleCache[pc] = level
+	}
+	global.vModuleMu.Unlock()
+
+	outputs.mu.Lock()
+	state.outputs = outputs.dest
+	outputs.mu.Unlock()
+	return state
+}
+
+// Restore restores the settings captured by CaptureState. Log files that the
+// shim manages for severities without an output are left alone. klog v2
+// cannot go back to its own log files once an output was set, so for the
+// severities that had no output the shim's log files take their place.
+func (state *State) Restore() {
+	for _, name := range stateFlags {
+		// The values were valid when they were captured.
+		v2flags.Set(name, state.flags[name]) // ignore error
+	}
+
+	global.vModuleMu.Lock()
+	global.vModuleFilter = state.vModuleFilter
+	global.vModuleCache = make(map[uintptr]Level, len(state.vModuleCache))
+	for pc, level := range state.vModuleCache {
+		global.vModuleCache[pc] = level
+	}
+	global.vModuleMu.Unlock()
+
+	for s := infoLog; s < numSeverity; s++ {
+		if _, ok := state.outputs[s].(*syncBuffer); ok {
+			continue
+		}
+		if state.outputs[s] == nil {
+			outputs.mu.Lock()
+			if _, ok := outputs.dest[s].(*syncBuffer); !ok && outputs.dest[s] != nil {
+				logFiles.installFile(s)
+			}
+			outputs.mu.Unlock()
+			continue
+		}
+		setOutput(s, state.outputs[s])
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "k8s.io/klog"
+)
+
+func TestCaptureState(t *testing.T) {
+	flagset, testCleanup := testSetup(t, "v", "1", "vmodule", "klog_state_test=3", "stderrthreshold", "FATAL")
+	defer testCleanup()
+
+	state := CaptureState()
+	for _, arg := range [][2]string{
+		{"v", "5"},
+		{"vmodule", "other=4"},
+		{"stderrthreshold", "INFO"},
+		{"log_backtrace_at", "klog_state_test.go:1"},
+	} {
+		if err := flagset.Set(arg[0], arg[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	SetOutputBySeverity("INFO", &buf)
+	if !V(4) {
+		t.Fatal("V(4) not enabled")
+	}
+	state.Restore()
+
+	for name, expected := range map[string]string{
+		"v":                "1",
+		"vmodule":          "klog_state_test=3",
+		"stderrthreshold":  "3",
+		"log_backtrace_at": ":0",
+	} {
+		if got := flagset.Lookup(name).Value.String(); got != expected {
+			t.Errorf("-%s is %q, want %q", name, got, expected)
+		}
+	}
+	if V(4) || !V(3) {
+		t.Error("vmodule was not restored")
+	}
+	Info("restored")
+	if buf.Len() != 0 || len(messages(contents(infoLog))) != 1 {
+		t.Errorf("INFO output was not restored, got %q and %q", buf.String(), contents(infoLog))
+	}
+}
+
+func TestRestoreLogFiles(t *testing.T) {
+	flagset, testCleanup := testSetup(t)
+	defer testCleanup()
+	flagset.Set("log_dir", os.TempDir())
+	// The default state, in which klog v2 writes its own log files.
+	SetOutput(nil)
+
+	state := CaptureState()
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	state.Restore()
+	Info("restored")
+	Flush()
+
+	b, err := ioutil.ReadFile(filepath.Join(os.TempDir(), filepath.Base(os.Args[0])+".INFO"))
+	if err != nil {
+		t.Fatalf("log file was not created: %v", err)
+	}
+	if buf.Len() != 0 || !strings.Contains(string(b), "] restored\n") {
+		t.Errorf("output was not restored, got %q and log file %q", buf.String(), b)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Statistics beyond the ones kept by klog v2.
+
+package klog
+
+import (
+	"io"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// numStatsLevels is the number of V levels that are counted separately.
+// Higher levels are counted together with the highest one.
+const numStatsLevels = 16
+
+// ExtendedOutputStats tracks statistics that klog v2 does not keep in Stats.
+// Use Snapshot to read them.
+type ExtendedOutputStats struct {
+	vEvaluated      [numStatsLevels]int64
+	vEnabled        [numStatsLevels]int64
+	vEnabledVmodule [numStatsLevels]int64
+	vLogged         [numStatsLevels]int64
+	writeErrors     [numSeverity]int64
+	rotations       [numSeverity]int64
+	suppressed      [numSeverity]int64
+	// lines and bytes count the lines that the shim writes itself, which
+	// klog v2 does not count in Stats.
+	lines [numSeverity]int64
+	bytes [numSeverity]int64
+}
+
+// ExtendedStats tracks the statistics of the shim, in addition to Stats.
+var ExtendedStats ExtendedOutputStats
+
+// SeverityStats is a snapshot of the statistics for one severity.
+type SeverityStats struct {
+	// Lines and Bytes are the number of lines and bytes written: those
+	// counted in Stats, which klog v2 writes, and those that the shim
+	// writes itself. klog v2 does not count FATAL lines.
+	Lines, Bytes int64
+	// DroppedLines and DroppedBytes are the number of lines and bytes
+	// dropped from the output by the asynchronous output.
+	DroppedLines, DroppedBytes int64
+	// SuppressedLines is the number of lines suppressed by rate limits.
+	SuppressedLines int64
+	// WriteErrors is the number of writes to the output that failed.
+	// Only outputs set through this package are counted.
+	WriteErrors int64
+	// Rotations is the number of times the log file was rotated. Only
+	// log files managed by this package are counted.
+	Rotations int64
+}
+
+// VStats is a snapshot of the statistics for one V level.
+type VStats struct {
+	// Evaluated is the number of calls to V for the level.
+	Evaluated int64
+	// Enabled is the number of those calls that returned true.
+	Enabled int64
+	// EnabledByVmodule is the number of calls that returned true only
+	// because of a -vmodule pattern for the calling file.
+	EnabledByVmodule int64
+	// Logged is the number of lines logged with the Info, Infoln and
+	// Infof methods of Verbose and Sampled. Verbose does not know its
+	// level, so a line is counted at the level of the last enabled V
+	// call on the same source line; lines logged on other source lines
+	// than the call to V are not counted.
+	Logged int64
+}
+
+// StatsSnapshot is a copy of all statistics at one point in time.
+type StatsSnapshot struct {
+	Info, Warning, Error, Fatal SeverityStats
+	// V holds the statistics of the V levels from 0 up. The last entry
+	// also counts all higher levels.
+	V []VStats
+}
+
+// Snapshot returns a copy of the current statistics.
+func (s *ExtendedOutputStats) Snapshot() StatsSnapshot {
+	snapshot := StatsSnapshot{
+		V: make([]VStats, numStatsLevels),
+	}
+	for sev, ss := range []*SeverityStats{&snapshot.Info, &snapshot.Warning, &snapshot.Error, &snapshot.Fatal} {
+		ss.Lines = atomic.LoadInt64(&s.lines[sev])
+		ss.Bytes = atomic.LoadInt64(&s.bytes[sev])
+		if stats := severityStats[sev]; stats != nil {
+			ss.Lines += stats.Lines()
+			ss.Bytes += stats.Bytes()
+		}
+		if dropped := droppedStats[sev]; dropped != nil {
+			ss.DroppedLines = dropped.Lines()
+			ss.DroppedBytes = dropped.Bytes()
+		}
+		ss.SuppressedLines = atomic.LoadInt64(&s.suppressed[sev])
+		ss.WriteErrors = atomic.LoadInt64(&s.writeErrors[sev])
+		ss.Rotations = atomic.LoadInt64(&s.rotations[sev])
+	}
+	for i := range snapshot.V {
+		snapshot.V[i] = VStats{
+			Evaluated:        atomic.LoadInt64(&s.vEvaluated[i]),
+			Enabled:          atomic.LoadInt64(&s.vEnabled[i]),
+			EnabledByVmodule: atomic.LoadInt64(&s.vEnabledVmodule[i]),
+			Logged:           atomic.LoadInt64(&s.vLogged[i]),
+		}
+	}
+	return snapshot
+}
+
+var severityStats = [numSeverity]*OutputStats{
+	infoLog:    &Stats.Info,
+	warningLog: &Stats.Warning,
+	errorLog:   &Stats.Error,
+}
+
+// countLine counts a line of n bytes of severity s written by the shim.
+// OutputStats has no exported way to add it to Stats.
+func (s *ExtendedOutputStats) countLine(sev severity, n int) {
+	atomic.AddInt64(&s.lines[sev], 1)
+	atomic.AddInt64(&s.bytes[sev], int64(n))
+}
+
+// vSites holds the level of the last enabled V call of each source line, for
+// counting the lines logged through Verbose.
+var vSites struct {
+	names  sync.Map // pc of a call site -> "file:line"
+	levels sync.Map // "file:line" -> Level
+}
+
+// callSite returns the source line of the call that is depth frames above
+// the caller of callSite.
+func callSite(depth int) (string, bool) {
+	var pcs [1]uintptr
+	if runtime.Callers(depth+2, pcs[:]) == 0 {
+		return "", false
+	}
+	if name, ok := vSites.names.Load(pcs[0]); ok {
+		return name.(string), true
+	}
+	frame, _ := runtime.CallersFrames(pcs[:]).Next()
+	name := frame.File + ":" + strconv.Itoa(frame.Line)
+	vSites.names.Store(pcs[0], name)
+	return name, true
+}
+
+// statsLevel returns the index of level in the V statistics.
+func statsLevel(level Level) int {
+	i := int(level)
+	if i < 0 {
+		i = 0
+	} else if i >= numStatsLevels {
+		i = numStatsLevels - 1
+	}
+	return i
+}
+
+// countV counts a call to V for level and its result. It is called by V.
+func (s *ExtendedOutputStats) countV(level Level, enabled, byVmodule bool) {
+	i := statsLevel(level)
+	atomic.AddInt64(&s.vEvaluated[i], 1)
+	if enabled {
+		atomic.AddInt64(&s.vEnabled[i], 1)
+		if site, ok := callSite(2); ok {
+			if l, ok := vSites.levels.Load(site); !ok || l.(Level) != level {
+				vSites.levels.Store(site, level)
+			}
+		}
+	}
+	if byVmodule {
+		atomic.AddInt64(&s.vEnabledVmodule[i], 1)
+	}
+}
+
+// countVLine counts a line logged through Verbose or Sampled at the level of
+// the V call on the same source line. It is called by their Info methods.
+func (s *ExtendedOutputStats) countVLine() {
+	site, ok := callSite(2)
+	if !ok {
+		return
+	}
+	if level, ok := vSites.levels.Load(site); ok {
+		atomic.AddInt64(&s.vLogged[statsLevel(level.(Level))], 1)
+	}
+}
+
+// statsOutput counts the failed writes to the output for a severity.
+type statsOutput struct {
+	sev severity
+	w   io.Writer
+}
+
+func (o *statsOutput) Write(p []byte) (int, error) {
+	n, err := o.w.Write(p)
+	if err != nil {
+		atomic.AddInt64(&ExtendedStats.writeErrors[o.sev], 1)
+	}
+	return n, err
+}
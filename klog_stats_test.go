@@ -0,0 +1,107 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	. "k8s.io/klog"
+)
+
+func TestVStats(t *testing.T) {
+	_, testCleanup := testSetup(t, "v", "2", "vmodule", "klog_stats_test=3")
+	defer testCleanup()
+
+	before := ExtendedStats.Snapshot()
+	V(1).Info("enabled by -v")
+	V(1).Sample(1).Info("sampled")
+	if V(2) {
+		// Only a condition.
+	}
+	V(3).Info("enabled by -vmodule")
+	V(4).Info("disabled")
+	after := ExtendedStats.Snapshot()
+
+	for level, expected := range map[int]VStats{
+		0: {},
+		1: {Evaluated: 2, Enabled: 2, EnabledByVmodule: 0, Logged: 2},
+		2: {Evaluated: 1, Enabled: 1, EnabledByVmodule: 0, Logged: 0},
+		3: {Evaluated: 1, Enabled: 1, EnabledByVmodule: 1, Logged: 1},
+		4: {Evaluated: 1, Enabled: 0, EnabledByVmodule: 0, Logged: 0},
+	} {
+		got := VStats{
+			Evaluated:        after.V[level].Evaluated - before.V[level].Evaluated,
+			Enabled:          after.V[level].Enabled - before.V[level].Enabled,
+			EnabledByVmodule: after.V[level].EnabledByVmodule - before.V[level].EnabledByVmodule,
+			Logged:           after.V[level].Logged - before.V[level].Logged,
+		}
+		if got != expected {
+			t.Errorf("V(%d): got %+v, expected %+v", level, got, expected)
+		}
+	}
+}
+
+// failingWriter is an output that fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestWriteErrorStats(t *testing.T) {
+	_, testCleanup := testSetup(t)
+	defer testCleanup()
+	SetOutputBySeverity("WARNING", failingWriter{})
+
+	before := ExtendedStats.Snapshot()
+	Warning("test")
+	Info("test")
+	after := ExtendedStats.Snapshot()
+
+	if got := after.Warning.WriteErrors - before.Warning.WriteErrors; got != 1 {
+		t.Errorf("got %d WARNING write errors, expected 1", got)
+	}
+	if got := after.Info.WriteErrors - before.Info.WriteErrors; got != 0 {
+		t.Errorf("got %d INFO write errors, expected 0", got)
+	}
+	if got := after.Warning.Lines - before.Warning.Lines; got != 1 {
+		t.Errorf("got %d WARNING lines, expected 1", got)
+	}
+}
+
+func TestShimLineStats(t *testing.T) {
+	for _, flags := range [][]string{
+		{"log_timestamp_utc", "true"},
+		{"log_format", "logfmt"},
+		{"log_format", "json"},
+	} {
+		t.Run(strings.Join(flags, "="), func(t *testing.T) {
+			_, testCleanup := testSetup(t, flags...)
+			defer testCleanup()
+
+			before := ExtendedStats.Snapshot().Info
+			Info("test")
+			after := ExtendedStats.Snapshot().Info
+			if got := after.Lines - before.Lines; got != 1 {
+				t.Errorf("got %d INFO lines, expected 1", got)
+			}
+			if got := after.Bytes - before.Bytes; got != int64(len(contents(infoLog))) {
+				t.Errorf("got %d INFO bytes, expected %d", got, len(contents(infoLog)))
+			}
+		})
+	}
+}
@@ -18,6 +18,7 @@ package klog_test
 
 import (
 	"bytes"
+	"compress/gzip"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -435,6 +436,249 @@ func TestRolloverHelperProcess(t *testing.T) {
 	}
 }
 
+func TestRotationInterval(t *testing.T) {
+	_, testCleanup := testSetup(t, "log_rotation_interval", "1h")
+	defer testCleanup()
+
+	now := time.Date(2020, time.July, 18, 10, 59, 0, 0, time.Local)
+	SetClock(ClockFunc(func() time.Time { return now }))
+	link := filepath.Join(os.TempDir(), filepath.Base(os.Args[0])+".INFO")
+
+	Info("before")
+	fname0, err := os.Readlink(link)
+	if err != nil {
+		t.Fatal("info wasn't created")
+	}
+
+	now = now.Add(30 * time.Second)
+	Info("still before")
+	if fname, _ := os.Readlink(link); fname != fname0 {
+		t.Errorf("rotated before the interval boundary: %v", fname)
+	}
+
+	now = now.Add(30 * time.Second)
+	Info("after")
+	fname1, err := os.Readlink(link)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(fname1, ".20200718-110000.") {
+		t.Errorf("rotated file has wrong time stamp: %v", fname1)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(os.TempDir(), fname0))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(b), "still before") || strings.Contains(string(b), "after\n") {
+		t.Errorf("wrong contents before rotation: %s", b)
+	}
+	b, err = ioutil.ReadFile(link)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(string(b), "before") || !strings.Contains(string(b), "after\n") {
+		t.Errorf("wrong contents after rotation: %s", b)
+	}
+}
+
+func TestRetentionMaxFiles(t *testing.T) {
+	_, testCleanup := testSetup(t, "log_rotation_interval", "1h", "log_max_files", "2")
+	defer testCleanup()
+
+	now := time.Date(2020, time.July, 18, 10, 0, 0, 0, time.Local)
+	SetClock(ClockFunc(func() time.Time { return now }))
+
+	for i := 0; i < 4; i++ {
+		Info("x")
+		now = now.Add(time.Hour)
+	}
+
+	infos, err := filepath.Glob(filepath.Join(os.TempDir(), "*.log.INFO.*"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("got %d INFO files, expected 2: %v", len(infos), infos)
+	}
+	for i, want := range []string{".20200718-120000.", ".20200718-130000."} {
+		if !strings.Contains(infos[i], want) {
+			t.Errorf("unexpected INFO file was kept: %v", infos[i])
+		}
+	}
+}
+
+func TestRetentionMaxAge(t *testing.T) {
+	_, testCleanup := testSetup(t, "log_max_age", "24h")
+	defer testCleanup()
+
+	// Files left behind by earlier runs of this program.
+	stale := writeOldLog(t, "INFO", 48*time.Hour)
+	recent := writeOldLog(t, "WARNING", time.Hour)
+
+	Info("x")
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale log file was not removed: %v", err)
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("recent log file was removed: %v", err)
+	}
+}
+
+func TestRetentionMaxTotalBytes(t *testing.T) {
+	_, testCleanup := testSetup(t)
+	defer testCleanup()
+	SetRetentionPolicy(RetentionPolicy{MaxTotalBytes: 1500})
+	defer SetRetentionPolicy(RetentionPolicy{})
+
+	oldest := writeOldLog(t, "ERROR", 3*time.Hour)
+	older := writeOldLog(t, "INFO", 2*time.Hour)
+	old := writeOldLog(t, "INFO", time.Hour)
+
+	Info("x")
+	for path, kept := range map[string]bool{oldest: false, older: false, old: true} {
+		if _, err := os.Stat(path); (err == nil) != kept {
+			t.Errorf("%s: expected kept=%v, got error %v", path, kept, err)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	_, testCleanup := testSetup(t, "log_rotation_interval", "1h", "log_compression", "gzip")
+	defer testCleanup()
+
+	now := time.Date(2020, time.July, 18, 10, 0, 0, 0, time.Local)
+	SetClock(ClockFunc(func() time.Time { return now }))
+	link := filepath.Join(os.TempDir(), filepath.Base(os.Args[0])+".INFO")
+
+	Info("before")
+	fname0, err := os.Readlink(link)
+	if err != nil {
+		t.Fatal("info wasn't created")
+	}
+	now = now.Add(time.Hour)
+	Info("after")
+	WaitForCompression()
+
+	fname1, err := os.Readlink(link)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fname1 == fname0 || strings.HasSuffix(fname1, ".gz") {
+		t.Errorf("symlink does not point to the live file: %v", fname1)
+	}
+	if _, err := os.Stat(filepath.Join(os.TempDir(), fname0)); !os.IsNotExist(err) {
+		t.Errorf("rotated file was not removed: %v", err)
+	}
+	f, err := os.Open(filepath.Join(os.TempDir(), fname0+".gz"))
+	if err != nil {
+		t.Fatalf("rotated file was not compressed: %v", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(b), "before") || strings.Contains(string(b), "after") {
+		t.Errorf("wrong contents of compressed file: %s", b)
+	}
+}
+
+func TestCompressionSameSecond(t *testing.T) {
+	_, testCleanup := testSetup(t, "log_compression", "gzip")
+	defer testCleanup()
+	defer func(size uint64) { klogv2.MaxSize = size }(klogv2.MaxSize)
+	klogv2.MaxSize = 512
+
+	now := time.Date(2020, time.July, 18, 10, 0, 0, 0, time.Local)
+	SetClock(ClockFunc(func() time.Time { return now }))
+	link := filepath.Join(os.TempDir(), filepath.Base(os.Args[0])+".INFO")
+
+	Info("before")
+	Info(strings.Repeat("x", 512)) // force a rollover within the same second
+	WaitForCompression()
+
+	fname, err := os.Readlink(link)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(os.TempDir(), fname+".gz")); !os.IsNotExist(err) {
+		t.Errorf("live file was compressed: %v", err)
+	}
+	b, err := ioutil.ReadFile(link)
+	if err != nil {
+		t.Fatalf("live file was removed: %v", err)
+	}
+	if !strings.Contains(string(b), "before") || !strings.Contains(string(b), strings.Repeat("x", 512)) {
+		t.Errorf("wrong contents of live file: %s", b)
+	}
+}
+
+func TestRotationSameSecond(t *testing.T) {
+	_, testCleanup := testSetup(t)
+	defer testCleanup()
+	defer func(size uint64) { klogv2.MaxSize = size }(klogv2.MaxSize)
+	klogv2.MaxSize = 512
+	SetRotationInterval(time.Hour)
+	defer SetRotationInterval(0)
+
+	now := time.Date(2020, time.July, 18, 10, 0, 0, 0, time.Local)
+	SetClock(ClockFunc(func() time.Time { return now }))
+	link := filepath.Join(os.TempDir(), filepath.Base(os.Args[0])+".INFO")
+	rotations := ExtendedStats.Snapshot().Info.Rotations
+
+	Info("before")
+	fname0, err := os.Readlink(link)
+	if err != nil {
+		t.Fatal("info wasn't created")
+	}
+	Info(strings.Repeat("x", 512)) // due for rotation within the same second
+	b, err := ioutil.ReadFile(link)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := strings.Count(string(b), "Log file created at:"); got != 1 {
+		t.Errorf("got %d headers in the continued file, expected 1", got)
+	}
+	if !strings.Contains(string(b), "before") || !strings.Contains(string(b), strings.Repeat("x", 512)) {
+		t.Errorf("wrong contents of continued file: %s", b)
+	}
+	if got := ExtendedStats.Snapshot().Info.Rotations - rotations; got != 0 {
+		t.Errorf("got %d rotations, expected 0", got)
+	}
+
+	// The continued file is full, so the first line of the next second
+	// goes to a new file.
+	now = now.Add(time.Second)
+	Info("after")
+	if fname1, _ := os.Readlink(link); fname1 == fname0 {
+		t.Errorf("full file was not rotated: %v", fname1)
+	}
+	if info, err := os.Stat(filepath.Join(os.TempDir(), fname0)); err != nil || info.Size() != int64(len(b)) {
+		t.Errorf("continued file was written to after rotation: %v, %v", info, err)
+	}
+}
+
+// writeOldLog creates a 1000 byte log file for the severity tag in the log
+// directory, as if it had been written by another run of this program that
+// long ago.
+func writeOldLog(t *testing.T, tag string, ago time.Duration) string {
+	t.Helper()
+	mtime := time.Now().Add(-ago)
+	path := filepath.Join(os.TempDir(), LogName(tag, mtime)+"0") // different pid
+	if err := ioutil.WriteFile(path, bytes.Repeat([]byte("x"), 1000), 0666); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return path
+}
+
 func TestOpenAppendOnStart(t *testing.T) {
 	_, testCleanup := testSetup(t)
 	defer testCleanup()
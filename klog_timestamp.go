@@ -0,0 +1,158 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Time stamp options.
+
+package klog
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TimestampFormat is the layout of the time stamp in glog headers.
+type TimestampFormat string
+
+const (
+	// GlogTimestamp is the layout of klog v2, mmdd hh:mm:ss.uuuuuu.
+	GlogTimestamp TimestampFormat = ""
+	// YearTimestamp adds the year, yyyymmdd hh:mm:ss.uuuuuu.
+	YearTimestamp TimestampFormat = "year"
+	// RFC3339NanoTimestamp is RFC 3339 with nanoseconds and the zone, as in
+	// 2020-10-16T12:00:00.000000000+02:00.
+	RFC3339NanoTimestamp TimestampFormat = "rfc3339nano"
+)
+
+// TimestampOptions define the time stamps of log lines and log file names.
+type TimestampOptions struct {
+	// Format is the layout of the time stamp in glog headers. The logfmt
+	// and JSON formats always use RFC 3339, with nanoseconds if Format is
+	// RFC3339NanoTimestamp and microseconds otherwise.
+	Format TimestampFormat
+	// UTC makes time stamps use UTC rather than local time, including
+	// those in the names and headers of log files and the day boundaries
+	// of SetRotationInterval.
+	UTC bool
+}
+
+var timestamps struct {
+	mu   sync.Mutex
+	opts TimestampOptions
+}
+
+// SetTimestampOptions sets the time stamp options. Options other than the
+// default ones make the shim format glog headers, see SetFormatter.
+func SetTimestampOptions(opts TimestampOptions) error {
+	switch opts.Format {
+	case GlogTimestamp, YearTimestamp, RFC3339NanoTimestamp:
+	default:
+		return fmt.Errorf("unknown time stamp format %q", opts.Format)
+	}
+	timestamps.mu.Lock()
+	timestamps.opts = opts
+	timestamps.mu.Unlock()
+	if currentFormatter() != nil && v2flag("logtostderr") != "true" {
+		logFiles.install(false)
+	}
+	return nil
+}
+
+// timestampOptions returns the time stamp options.
+func timestampOptions() TimestampOptions {
+	timestamps.mu.Lock()
+	defer timestamps.mu.Unlock()
+	return timestamps.opts
+}
+
+// logTime returns the current time in the time zone of time stamps.
+func logTime() time.Time {
+	if timestampOptions().UTC {
+		return timeNow().UTC()
+	}
+	return timeNow()
+}
+
+// layout returns the time layout of f in glog headers.
+func (f TimestampFormat) layout() string {
+	switch f {
+	case YearTimestamp:
+		return "20060102 15:04:05.000000"
+	case RFC3339NanoTimestamp:
+		return "2006-01-02T15:04:05.000000000Z07:00"
+	}
+	return "0102 15:04:05.000000"
+}
+
+// description returns the layout of f as it is described in the header of
+// log files.
+func (f TimestampFormat) description() string {
+	switch f {
+	case YearTimestamp:
+		return "yyyymmdd hh:mm:ss.uuuuuu"
+	case RFC3339NanoTimestamp:
+		return "yyyy-mm-ddThh:mm:ss.nnnnnnnnnZ"
+	}
+	return "mmdd hh:mm:ss.uuuuuu"
+}
+
+// isoLayout returns the time layout of the logfmt and JSON formats.
+func (f TimestampFormat) isoLayout() string {
+	if f == RFC3339NanoTimestamp {
+		return "2006-01-02T15:04:05.000000000Z07:00"
+	}
+	return "2006-01-02T15:04:05.000000Z07:00"
+}
+
+// timestampFormatValue adapts the time stamp format to the flag.Value
+// interface.
+type timestampFormatValue struct{}
+
+func (timestampFormatValue) String() string {
+	if f := timestampOptions().Format; f != GlogTimestamp {
+		return string(f)
+	}
+	return "glog"
+}
+
+func (timestampFormatValue) Set(value string) error {
+	opts := timestampOptions()
+	opts.Format = TimestampFormat(value)
+	if value == "glog" {
+		opts.Format = GlogTimestamp
+	}
+	return SetTimestampOptions(opts)
+}
+
+// timestampUTCValue adapts the UTC option to the flag.Value interface.
+type timestampUTCValue struct{}
+
+func (timestampUTCValue) String() string {
+	return strconv.FormatBool(timestampOptions().UTC)
+}
+
+func (timestampUTCValue) Set(value string) error {
+	utc, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	opts := timestampOptions()
+	opts.UTC = utc
+	return SetTimestampOptions(opts)
+}
+
+func (timestampUTCValue) IsBoolFlag() bool {
+	return true
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	. "k8s.io/klog"
+)
+
+// cest is a time zone that is never the one of UTC.
+var cest = time.FixedZone("CEST", 2*60*60)
+
+func TestTimestampFormats(t *testing.T) {
+	tests := map[string]struct {
+		flags    []string
+		expected string
+	}{
+		"year": {
+			flags:    []string{"log_timestamp_format", "year"},
+			expected: "I20201016 12:00:00.123456",
+		},
+		"utc": {
+			flags:    []string{"log_timestamp_utc", "true"},
+			expected: "I1016 10:00:00.123456",
+		},
+		"rfc3339nano": {
+			flags:    []string{"log_timestamp_format", "rfc3339nano"},
+			expected: "I2020-10-16T12:00:00.123456789+02:00",
+		},
+		"rfc3339nano utc": {
+			flags:    []string{"log_timestamp_format", "rfc3339nano", "log_timestamp_utc", "true"},
+			expected: "I2020-10-16T10:00:00.123456789Z",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, testCleanup := testSetup(t, test.flags...)
+			defer testCleanup()
+			SetClock(ClockFunc(func() time.Time {
+				return time.Date(2020, 10, 16, 12, 0, 0, 123456789, cest)
+			}))
+
+			Info("test")
+			_, _, line, _ := runtime.Caller(0)
+			expected := fmt.Sprintf("%s %7d klog_timestamp_test.go:%d] test\n", test.expected, os.Getpid(), line-1)
+			if got := contents(infoLog); got != expected {
+				t.Errorf("got %q, expected %q", got, expected)
+			}
+		})
+	}
+}
+
+func TestTimestampLogfmt(t *testing.T) {
+	_, testCleanup := testSetup(t, "log_format", "logfmt", "log_timestamp_format", "rfc3339nano", "log_timestamp_utc", "true")
+	defer testCleanup()
+	SetClock(ClockFunc(func() time.Time {
+		return time.Date(2020, 10, 16, 12, 0, 0, 123456789, cest)
+	}))
+
+	Info("test")
+	if got := contents(infoLog); !strings.HasPrefix(got, "ts=2020-10-16T10:00:00.123456789Z level=info ") {
+		t.Errorf("unexpected output %q", got)
+	}
+}
+
+func TestTimestampUTCFileNames(t *testing.T) {
+	_, testCleanup := testSetup(t, "log_rotation_interval", "24h", "log_timestamp_utc", "true")
+	defer testCleanup()
+	now := time.Date(2020, 10, 16, 1, 0, 0, 0, cest)
+	SetClock(ClockFunc(func() time.Time { return now }))
+	link := filepath.Join(os.TempDir(), filepath.Base(os.Args[0])+".INFO")
+
+	Info("before")
+	// Midnight in UTC, not in local time, is an interval boundary.
+	now = time.Date(2020, 10, 16, 2, 0, 0, 0, cest)
+	Info("after")
+
+	fname, err := os.Readlink(link)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(fname, ".20201016-000000.") {
+		t.Errorf("log file has wrong time stamp: %v", fname)
+	}
+	b, err := ioutil.ReadFile(link)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(b), "Log file created at: 2020/10/16 00:00:00\n") || !strings.Contains(string(b), "I1016 00:00:00.000000 ") {
+		t.Errorf("log file has wrong time stamps: %s", b)
+	}
+}
+
+func TestTimestampFormatInvalid(t *testing.T) {
+	if err := SetTimestampOptions(TimestampOptions{Format: "iso"}); err == nil {
+		t.Error("SetTimestampOptions should have failed for an unknown format")
+	}
+}
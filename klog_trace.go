@@ -0,0 +1,114 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Correlation of log lines with traces.
+
+package klog
+
+import (
+	"context"
+	"sync"
+)
+
+// The keys of the key/value pairs with the IDs of the trace and span that
+// the context of a line carries.
+const (
+	TraceIDKey = "trace_id"
+	SpanIDKey  = "span_id"
+)
+
+// A TraceExtractor returns the IDs of the trace and span that a context
+// carries, as in the hexadecimal IDs of OpenTelemetry, which lets klog
+// correlate lines with traces without depending on a tracing library:
+//
+//	klog.SetTraceExtractor(klog.TraceExtractorFunc(func(ctx context.Context) (string, string, bool) {
+//		sc := trace.SpanContextFromContext(ctx)
+//		return sc.TraceID().String(), sc.SpanID().String(), sc.IsValid()
+//	}))
+type TraceExtractor interface {
+	TraceIDs(ctx context.Context) (traceID, spanID string, ok bool)
+}
+
+// TraceExtractorFunc is a function that implements TraceExtractor.
+type TraceExtractorFunc func(ctx context.Context) (traceID, spanID string, ok bool)
+
+// TraceIDs implements TraceExtractor.
+func (f TraceExtractorFunc) TraceIDs(ctx context.Context) (string, string, bool) {
+	return f(ctx)
+}
+
+var tracing struct {
+	mu sync.RWMutex
+	x  TraceExtractor
+}
+
+// SetTraceExtractor sets the extractor of the trace and span IDs for the
+// functions that take a context, such as InfoSContext, and for the loggers
+// of klogr.FromContext. There is none by default; SetTraceExtractor(nil)
+// removes it.
+func SetTraceExtractor(x TraceExtractor) {
+	tracing.mu.Lock()
+	defer tracing.mu.Unlock()
+	tracing.x = x
+}
+
+// TraceValues returns the key/value pairs with the IDs of the trace and span
+// that ctx carries, or nil if there is no extractor or ctx carries no span.
+func TraceValues(ctx context.Context) []interface{} {
+	tracing.mu.RLock()
+	x := tracing.x
+	tracing.mu.RUnlock()
+	if x == nil || ctx == nil {
+		return nil
+	}
+	traceID, spanID, ok := x.TraceIDs(ctx)
+	if !ok {
+		return nil
+	}
+	return []interface{}{TraceIDKey, traceID, SpanIDKey, spanID}
+}
+
+// withTraceValues returns keysAndValues preceded by the trace values of ctx.
+func withTraceValues(ctx context.Context, keysAndValues []interface{}) []interface{} {
+	if kvs := TraceValues(ctx); kvs != nil {
+		return append(kvs, keysAndValues...)
+	}
+	return keysAndValues
+}
+
+// InfoSContext is like InfoS, with the IDs of the trace and span that ctx
+// carries, as in
+//
+//	"Pod status updated" trace_id="4bf92f3577b34da6a3ce929d0e0e4736" span_id="00f067aa0ba902b7" pod="kubedns"
+func InfoSContext(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	logDepth(infoLog, 1, structuredEntry(nil, msg, withTraceValues(ctx, keysAndValues)), false)
+}
+
+// InfoSContextDepth acts as InfoSContext but uses depth to determine which
+// call frame to log.
+func InfoSContextDepth(ctx context.Context, depth int, msg string, keysAndValues ...interface{}) {
+	logDepth(infoLog, depth+1, structuredEntry(nil, msg, withTraceValues(ctx, keysAndValues)), false)
+}
+
+// ErrorSContext is like ErrorS, with the IDs of the trace and span that ctx
+// carries.
+func ErrorSContext(ctx context.Context, err error, msg string, keysAndValues ...interface{}) {
+	logDepth(errorLog, 1, structuredEntry(err, msg, withTraceValues(ctx, keysAndValues)), false)
+}
+
+// ErrorSContextDepth acts as ErrorSContext but uses depth to determine which
+// call frame to log.
+func ErrorSContextDepth(ctx context.Context, depth int, err error, msg string, keysAndValues ...interface{}) {
+	logDepth(errorLog, depth+1, structuredEntry(err, msg, withTraceValues(ctx, keysAndValues)), false)
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	. "k8s.io/klog"
+)
+
+// spanKey is the context key of the fake spans.
+type spanKey struct{}
+
+// fakeExtractor extracts the IDs of a [2]string in the context.
+var fakeExtractor = TraceExtractorFunc(func(ctx context.Context) (string, string, bool) {
+	ids, ok := ctx.Value(spanKey{}).([2]string)
+	return ids[0], ids[1], ok
+})
+
+func TestTraceValues(t *testing.T) {
+	_, testCleanup := testSetup(t)
+	defer testCleanup()
+	ctx := context.WithValue(context.Background(), spanKey{}, [2]string{"4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"})
+
+	InfoSContext(ctx, "no extractor", "pod", "kubedns")
+	SetTraceExtractor(fakeExtractor)
+	defer SetTraceExtractor(nil)
+	InfoSContext(ctx, "Pod status updated", "pod", "kubedns")
+	InfoSContext(context.Background(), "no span")
+	ErrorSContext(ctx, errors.New("timeout"), "Sync failed")
+
+	expected := []string{
+		`"no extractor" pod="kubedns"`,
+		`"Pod status updated" trace_id="4bf92f3577b34da6a3ce929d0e0e4736" span_id="00f067aa0ba902b7" pod="kubedns"`,
+		`"no span"`,
+		`"Sync failed" err="timeout" trace_id="4bf92f3577b34da6a3ce929d0e0e4736" span_id="00f067aa0ba902b7"`,
+	}
+	if got := messages(contents(infoLog)); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("got:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+	if !strings.Contains(contents(errorLog), "klog_trace_test.go:") {
+		t.Errorf("wrong caller in %q", contents(errorLog))
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Truncation of long log messages.
+
+package klog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// maxLineSize is the maximum message size of each severity, or zero.
+var maxLineSize [numSeverity]int64
+
+// SetMaxLineSize limits the messages of all severities to n bytes, not
+// counting the header that precedes them. Longer messages are cut at n bytes
+// and end with a "...[truncated N bytes]" marker instead. Zero removes the
+// limit. The stack traces written after a Fatal line are not part of its
+// message and are never truncated.
+func SetMaxLineSize(n int) {
+	for s := infoLog; s < numSeverity; s++ {
+		atomic.StoreInt64(&maxLineSize[s], int64(n))
+	}
+}
+
+// SetMaxLineSizeBySeverity sets the limit of SetMaxLineSize for the
+// messages of one severity, for example to exempt FATAL with zero.
+// Valid names are "INFO", "WARNING", "ERROR", and "FATAL". If the name is not
+// recognized, SetMaxLineSizeBySeverity panics.
+func SetMaxLineSizeBySeverity(name string, n int) {
+	s, ok := severityByName(name)
+	if !ok {
+		panic(fmt.Sprintf("SetMaxLineSizeBySeverity(%q): unrecognized severity name", name))
+	}
+	atomic.StoreInt64(&maxLineSize[s], int64(n))
+}
+
+// truncate applies the limit of severity s to msg.
+func truncate(s severity, msg string) string {
+	max := int(atomic.LoadInt64(&maxLineSize[s]))
+	newline := strings.HasSuffix(msg, "\n")
+	body := strings.TrimSuffix(msg, "\n")
+	if max <= 0 || len(body) <= max {
+		return msg
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(body[cut]) {
+		cut--
+	}
+	msg = fmt.Sprintf("%s...[truncated %d bytes]", body[:cut], len(body)-cut)
+	if newline {
+		msg += "\n"
+	}
+	return msg
+}
+
+// maxLineSizeValue adapts SetMaxLineSize and SetMaxLineSizeBySeverity to the
+// flag.Value interface. Its syntax is the limit of all severities followed
+// by overrides, as in "65536,FATAL=0".
+type maxLineSizeValue struct{}
+
+func (maxLineSizeValue) String() string {
+	def := atomic.LoadInt64(&maxLineSize[infoLog])
+	parts := []string{strconv.FormatInt(def, 10)}
+	for s := warningLog; s < numSeverity; s++ {
+		if n := atomic.LoadInt64(&maxLineSize[s]); n != def {
+			parts = append(parts, fmt.Sprintf("%s=%d", severityName[s], n))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (maxLineSizeValue) Set(value string) error {
+	var limits [numSeverity]int
+	for i, part := range strings.Split(value, ",") {
+		name, size := "", part
+		if eq := strings.Index(part, "="); eq >= 0 {
+			name, size = part[:eq], part[eq+1:]
+		}
+		n, err := strconv.Atoi(size)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid line size %q", size)
+		}
+		if name == "" {
+			if i > 0 {
+				return fmt.Errorf("the line size of all severities must come first in %q", value)
+			}
+			for s := range limits {
+				limits[s] = n
+			}
+			continue
+		}
+		s, ok := severityByName(name)
+		if !ok {
+			return fmt.Errorf("unrecognized severity name %q", name)
+		}
+		limits[s] = n
+	}
+	for s, n := range limits {
+		atomic.StoreInt64(&maxLineSize[s], int64(n))
+	}
+	return nil
+}
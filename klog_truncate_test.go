@@ -0,0 +1,79 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog_test
+
+import (
+	"strings"
+	"testing"
+
+	. "k8s.io/klog"
+)
+
+func TestMaxLineSize(t *testing.T) {
+	flagset, testCleanup := testSetup(t, "log_max_line_size", "10,ERROR=5,FATAL=0")
+	defer testCleanup()
+
+	if got := flagset.Lookup("log_max_line_size").Value.String(); got != "10,ERROR=5,FATAL=0" {
+		t.Errorf("got flag value %q", got)
+	}
+
+	Info("0123456789")
+	Info("0123456789abc")
+	Infoln("0123456789", "abc")
+	Info("ünïcödé ünïcödé")
+	Error("0123456789")
+	Flush()
+
+	expected := []string{
+		"0123456789",
+		"0123456789...[truncated 3 bytes]",
+		"0123456789...[truncated 4 bytes]",
+		"ünïcöd...[truncated 14 bytes]",
+		"01234...[truncated 5 bytes]",
+	}
+	if got := messages(contents(infoLog)); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+	if !strings.HasPrefix(contents(errorLog), "E") {
+		t.Errorf("the header of the truncated line is missing: %q", contents(errorLog))
+	}
+}
+
+func TestMaxLineSizeAPI(t *testing.T) {
+	flagset, testCleanup := testSetup(t)
+	defer testCleanup()
+	SetMaxLineSize(4)
+	SetMaxLineSizeBySeverity("WARNING", 0)
+
+	if got := flagset.Lookup("log_max_line_size").Value.String(); got != "4,WARNING=0" {
+		t.Errorf("got flag value %q", got)
+	}
+	Warning("not truncated")
+	Info("truncated")
+	expected := []string{"not truncated", "trun...[truncated 5 bytes]"}
+	if got := messages(contents(infoLog)); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
+
+func TestMaxLineSizeInvalid(t *testing.T) {
+	flagset, testCleanup := testSetup(t)
+	defer testCleanup()
+	for _, value := range []string{"x", "-1", "INFO=1,2", "DEBUG=3"} {
+		if err := flagset.Set("log_max_line_size", value); err == nil {
+			t.Errorf("%q should have been rejected", value)
+		}
+	}
+}
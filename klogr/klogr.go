@@ -4,10 +4,14 @@ package klogr
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"runtime"
 	"sort"
+	"strings"
+	"sync"
+	"unicode"
 
 	"github.com/go-logr/logr"
 	"k8s.io/klog"
@@ -104,6 +108,94 @@ func trimDuplicates(kvLists ...[]interface{}) [][]interface{} {
 	return outs
 }
 
+// DefaultKeyDenylist are common keys of secrets, whose values are logged as
+// klog.Redacted by default.
+var DefaultKeyDenylist = []string{
+	"token", "password", "passwd", "secret", "authorization",
+	"apiKey", "credential", "credentials",
+}
+
+var denylist = struct {
+	mu   sync.RWMutex
+	keys [][]string // the segments of each key
+}{keys: keySegments(DefaultKeyDenylist)}
+
+// SetKeyDenylist sets the keys whose values are logged as klog.Redacted,
+// replacing DefaultKeyDenylist. Keys are split into segments at '_', '-',
+// '.' and camelCase boundaries and compared ignoring case, and a key is
+// denied if it contains the segments of a denied key in a row: "password"
+// masks "db_password" and "apiKey" masks "X-API-Key", but "token" does not
+// mask "tokens". SetKeyDenylist() logs all values.
+func SetKeyDenylist(keys ...string) {
+	denylist.mu.Lock()
+	defer denylist.mu.Unlock()
+	denylist.keys = keySegments(keys)
+}
+
+func keySegments(keys []string) [][]string {
+	segs := make([][]string, 0, len(keys))
+	for _, k := range keys {
+		if s := segments(k); len(s) > 0 {
+			segs = append(segs, s)
+		}
+	}
+	return segs
+}
+
+// segments splits key into lowercase segments at '_', '-' and '.' and where
+// the case changes: "X-Auth-Token", "xAuthToken" and "XAuthToken" all are
+// x, auth, token.
+func segments(key string) []string {
+	var segs []string
+	rs := []rune(key)
+	begin := 0
+	end := func(i int) {
+		if i > begin {
+			segs = append(segs, strings.ToLower(string(rs[begin:i])))
+		}
+	}
+	for i, r := range rs {
+		switch {
+		case r == '_' || r == '-' || r == '.':
+			end(i)
+			begin = i + 1
+		case i > begin && unicode.IsUpper(r) &&
+			(!unicode.IsUpper(rs[i-1]) || i+1 < len(rs) && unicode.IsLower(rs[i+1])):
+			end(i)
+			begin = i
+		}
+	}
+	end(len(rs))
+	return segs
+}
+
+// denied reports whether the value of key has to be masked.
+func denied(key string) bool {
+	denylist.mu.RLock()
+	defer denylist.mu.RUnlock()
+	if len(denylist.keys) == 0 {
+		return false
+	}
+	segs := segments(key)
+	for _, d := range denylist.keys {
+		for i := 0; i+len(d) <= len(segs); i++ {
+			if equal(segs[i:i+len(d)], d) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func equal(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func flatten(kvList ...interface{}) string {
 	keys := make([]string, 0, len(kvList))
 	vals := make(map[string]interface{}, len(kvList))
@@ -116,6 +208,9 @@ func flatten(kvList ...interface{}) string {
 		if i+1 < len(kvList) {
 			v = kvList[i+1]
 		}
+		if v != nil && denied(k) {
+			v = klog.Redacted
+		}
 		keys = append(keys, k)
 		vals[k] = v
 	}
@@ -138,13 +233,57 @@ func pretty(value interface{}) string {
 	return string(jb)
 }
 
+// structured returns the key/value pairs of a line for klog.InfoSDepth and
+// klog.ErrorSDepth, with the values of denied keys masked.
+func (l klogger) structured(kvList []interface{}) []interface{} {
+	var kvs []interface{}
+	if l.prefix != "" {
+		kvs = append(kvs, klog.LoggerKey, l.prefix)
+	}
+	return append(kvs, l.masked(kvList)...)
+}
+
+// masked returns the values of l followed by kvList without duplicate keys,
+// with the values of denied keys masked.
+func (l klogger) masked(kvList []interface{}) []interface{} {
+	trimmed := trimDuplicates(l.values, kvList)
+	var kvs []interface{}
+	for _, kv := range [][]interface{}{trimmed[0], trimmed[1]} {
+		for i := 0; i < len(kv); i += 2 {
+			k, v := kv[i], kv[i+1]
+			if key, ok := k.(string); ok && v != nil && denied(key) {
+				v = klog.Redacted
+			}
+			kvs = append(kvs, k, v)
+		}
+	}
+	return kvs
+}
+
+// entry returns the entry of a line written as text, which passes the
+// logger name, error and key/value pairs on to the klog sinks.
+func (l klogger) entry(severity string, err error, text string, kvList []interface{}) *klog.Entry {
+	return &klog.Entry{
+		Severity:      severity,
+		Logger:        l.prefix,
+		Message:       text,
+		Err:           err,
+		KeysAndValues: l.masked(kvList),
+	}
+}
+
 func (l klogger) Info(msg string, kvList ...interface{}) {
+	if l.Enabled() && klog.StructuredOutput() {
+		klog.InfoSDepth(framesToCaller(), msg, l.structured(kvList)...)
+		return
+	}
 	if l.Enabled() {
 		msgStr := flatten("msg", msg)
 		trimmed := trimDuplicates(l.values, kvList)
 		fixedStr := flatten(trimmed[0]...)
 		userStr := flatten(trimmed[1]...)
-		klog.InfoDepth(framesToCaller(), l.prefix, " ", msgStr, " ", fixedStr, " ", userStr)
+		text := fmt.Sprint(l.prefix, " ", msgStr, " ", fixedStr, " ", userStr)
+		klog.LogEntryDepth(framesToCaller(), l.entry("INFO", nil, text, kvList))
 	}
 }
 
@@ -153,6 +292,10 @@ func (l klogger) Enabled() bool {
 }
 
 func (l klogger) Error(err error, msg string, kvList ...interface{}) {
+	if klog.StructuredOutput() {
+		klog.ErrorSDepth(framesToCaller(), err, msg, l.structured(kvList)...)
+		return
+	}
 	msgStr := flatten("msg", msg)
 	var loggableErr interface{}
 	if err != nil {
@@ -162,7 +305,8 @@ func (l klogger) Error(err error, msg string, kvList ...interface{}) {
 	trimmed := trimDuplicates(l.values, kvList)
 	fixedStr := flatten(trimmed[0]...)
 	userStr := flatten(trimmed[1]...)
-	klog.ErrorDepth(framesToCaller(), l.prefix, " ", msgStr, " ", errStr, " ", fixedStr, " ", userStr)
+	text := fmt.Sprint(l.prefix, " ", msgStr, " ", errStr, " ", fixedStr, " ", userStr)
+	klog.LogEntryDepth(framesToCaller(), l.entry("ERROR", err, text, kvList))
 }
 
 func (l klogger) V(level int) logr.InfoLogger {
@@ -191,3 +335,27 @@ func (l klogger) WithValues(kvList ...interface{}) logr.Logger {
 
 var _ logr.Logger = klogger{}
 var _ logr.InfoLogger = klogger{}
+
+// contextKey is the key of the logger in a context.
+type contextKey struct{}
+
+// NewContext returns a copy of ctx that carries logger, so that code which
+// is passed ctx logs with FromContext(ctx) to logger, for example the logger
+// of a ktesting.Capture.
+func NewContext(ctx context.Context, logger logr.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger of ctx, as set by NewContext, or else
+// New(), with the IDs of the trace and span that ctx carries as values if
+// klog has a trace extractor, see klog.SetTraceExtractor.
+func FromContext(ctx context.Context) logr.Logger {
+	logger, ok := ctx.Value(contextKey{}).(logr.Logger)
+	if !ok {
+		logger = New()
+	}
+	if kvs := klog.TraceValues(ctx); kvs != nil {
+		logger = logger.WithValues(kvs...)
+	}
+	return logger
+}
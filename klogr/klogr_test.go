@@ -2,7 +2,12 @@ package klogr_test
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"flag"
+	"io/ioutil"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/go-logr/logr"
@@ -71,6 +76,13 @@ func TestInfo(t *testing.T) {
 			text:          "test",
 			keysAndValues: []interface{}{"akey", "avalue", "akey2"},
 			expectedOutput: ` "msg"="test" "basekey1"="basevar1" "basekey2"=null "akey"="avalue" "akey2"=null
+`,
+		},
+		"should mask the values of the default denied keys": {
+			klogr:         New().WithValues("password", "hunter2"),
+			text:          "test",
+			keysAndValues: []interface{}{"accessToken", "abc", "user", "admin"},
+			expectedOutput: ` "msg"="test" "password"="[REDACTED]" "accessToken"="[REDACTED]" "user"="admin"
 `,
 		},
 	}
@@ -96,3 +108,117 @@ func TestInfo(t *testing.T) {
 		})
 	}
 }
+
+func TestSetKeyDenylist(t *testing.T) {
+	defer SetKeyDenylist(DefaultKeyDenylist...)
+
+	for _, test := range []struct {
+		keys           []string
+		expectedOutput string
+	}{
+		{
+			keys: nil,
+			expectedOutput: ` "msg"="test"  "accessToken"="abc" "password"="hunter2" "secret"=null "secretName"="db" "user"="admin"
+`,
+		},
+		{
+			keys: []string{"USER"},
+			expectedOutput: ` "msg"="test"  "accessToken"="abc" "password"="hunter2" "secret"=null "secretName"="db" "user"="[REDACTED]"
+`,
+		},
+		{
+			keys: DefaultKeyDenylist,
+			expectedOutput: ` "msg"="test"  "accessToken"="[REDACTED]" "password"="[REDACTED]" "secret"=null "secretName"="[REDACTED]" "user"="admin"
+`,
+		},
+	} {
+		SetKeyDenylist(test.keys...)
+		tmpWriteBuffer := bytes.NewBuffer(nil)
+		klog.SetOutput(tmpWriteBuffer)
+		New().Info("test", "user", "admin", "password", "hunter2", "accessToken", "abc", "secretName", "db", "secret")
+		klog.Flush()
+		if actual := tmpWriteBuffer.String(); actual != test.expectedOutput {
+			t.Errorf("denylist %q: expected %q did not match actual %q", test.keys, test.expectedOutput, actual)
+		}
+	}
+}
+
+func TestKeySegments(t *testing.T) {
+	for key, masked := range map[string]bool{
+		"db_password":     true,
+		"X-Auth-Token":    true,
+		"clientSecretKey": true,
+		"APIKey":          true,
+		"x.api_key":       true,
+		"PASSWORD":        true,
+		"tokens":          false,
+		"passwordless":    false,
+		"user":            false,
+		"keyAPI":          false,
+	} {
+		tmpWriteBuffer := bytes.NewBuffer(nil)
+		klog.SetOutput(tmpWriteBuffer)
+		New().Info("test", key, "value")
+		klog.Flush()
+		if got := strings.Contains(tmpWriteBuffer.String(), `"[REDACTED]"`); got != masked {
+			t.Errorf("%s: got masked=%v, expected %v: %q", key, got, masked, tmpWriteBuffer.String())
+		}
+	}
+}
+
+func TestLogfmt(t *testing.T) {
+	flag.CommandLine.Set("log_format", "logfmt")
+	defer flag.CommandLine.Set("log_format", "glog")
+
+	tmpWriteBuffer := bytes.NewBuffer(nil)
+	klog.SetOutput(ioutil.Discard)
+	klog.SetOutputBySeverity("INFO", tmpWriteBuffer)
+	logger := New().WithName("controller").WithValues("akey", "avalue", "token", "abc")
+	logger.Info("test", "akey", "avalue2", "bkey", 1)
+	logger.Error(errors.New("failed"), "test")
+	klog.Flush()
+
+	lines := strings.Split(strings.TrimSuffix(tmpWriteBuffer.String(), "\n"), "\n")
+	expected := []string{
+		`level=info caller=klogr_test.go:`,
+		` logger=controller msg="test" token=[REDACTED] akey=avalue2 bkey=1`,
+		`level=error caller=klogr_test.go:`,
+		` logger=controller msg="test" err=failed akey=avalue token=[REDACTED]`,
+	}
+	if len(lines) != 2 ||
+		!strings.Contains(lines[0], expected[0]) || !strings.HasSuffix(lines[0], expected[1]) ||
+		!strings.Contains(lines[1], expected[2]) || !strings.HasSuffix(lines[1], expected[3]) {
+		t.Errorf("unexpected output %q", lines)
+	}
+}
+
+func TestContext(t *testing.T) {
+	if got := FromContext(context.Background()); !reflect.DeepEqual(got, New()) {
+		t.Errorf("got %v, want New()", got)
+	}
+	logger := New().WithName("ctx")
+	if got := FromContext(NewContext(context.Background(), logger)); !reflect.DeepEqual(got, logger) {
+		t.Errorf("got %v, want %v", got, logger)
+	}
+}
+
+// spanKey is the context key of the fake spans.
+type spanKey struct{}
+
+func TestContextTrace(t *testing.T) {
+	klog.SetTraceExtractor(klog.TraceExtractorFunc(func(ctx context.Context) (string, string, bool) {
+		ids, ok := ctx.Value(spanKey{}).([2]string)
+		return ids[0], ids[1], ok
+	}))
+	defer klog.SetTraceExtractor(nil)
+
+	ctx := context.WithValue(context.Background(), spanKey{}, [2]string{"4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"})
+	logger := New().WithName("ctx")
+	expected := logger.WithValues(klog.TraceIDKey, "4bf92f3577b34da6a3ce929d0e0e4736", klog.SpanIDKey, "00f067aa0ba902b7")
+	if got := FromContext(NewContext(ctx, logger)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, want %v", got, expected)
+	}
+	if got := FromContext(context.Background()); !reflect.DeepEqual(got, New()) {
+		t.Errorf("got %v for a context without a span, want New()", got)
+	}
+}
@@ -0,0 +1,330 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ktesting captures the lines that a test logs through k8s.io/klog.
+//
+// A Capture receives the lines logged from the goroutines of its test, and
+// through its Logger, which can be passed to the code under test in a context
+// with klogr.NewContext. The lines are also passed to t.Log, so that they show
+// up with the test that logged them:
+//
+//	func TestSync(t *testing.T) {
+//		c := ktesting.New(t)
+//		defer c.Stop()
+//		ctx := klogr.NewContext(context.Background(), c.Logger())
+//		c.Go(func() { sync(ctx) })
+//		...
+//		c.ExpectLogged("ERROR", `sync failed`)
+//	}
+package ktesting
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-logr/logr"
+	"k8s.io/klog"
+)
+
+// severities are the severity names, in increasing order.
+var severities = []string{"INFO", "WARNING", "ERROR", "FATAL"}
+
+// severityIndex returns the index of a severity name in severities, or -1.
+func severityIndex(name string) int {
+	for i, s := range severities {
+		if s == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Line is a captured log line.
+type Line struct {
+	Severity string
+	// Text is the message as the glog format shows it, see klog.Entry.Text.
+	Text string
+	// Formatted is the line in the glog format, header included.
+	Formatted string
+}
+
+// A Capture holds the lines logged by a test.
+type Capture struct {
+	t TB
+	// The lines are kept like the klog outputs in klog_test.go: a line
+	// of a severity is also in the buffers of the lower severities.
+	mu      sync.Mutex
+	lines   []Line
+	buffers [4]bytes.Buffer
+	stopped bool
+}
+
+// TB is the part of testing.TB that a Capture uses.
+type TB interface {
+	Helper()
+	Log(args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// captures dispatches the lines logged through klog to the Capture of the
+// goroutine that logs them.
+var captures struct {
+	// tracked is the number of goroutines, read without mu so that lines
+	// logged while none is tracked do not look up the goroutine ID.
+	tracked int32
+
+	mu         sync.Mutex
+	goroutines map[uint64]*Capture
+}
+
+// sink is the klog.Sink of all captures, added while there are goroutines
+// to capture.
+type sink struct{}
+
+func (sink) Log(e *klog.Entry) {
+	if atomic.LoadInt32(&captures.tracked) == 0 {
+		return
+	}
+	id := goroutineID()
+	captures.mu.Lock()
+	c := captures.goroutines[id]
+	captures.mu.Unlock()
+	if c != nil {
+		c.log(e)
+	}
+}
+
+// New returns a Capture for the test t, which captures the lines logged from
+// the calling goroutine until Stop is called. t is usually a *testing.T or
+// *testing.B.
+func New(t TB) *Capture {
+	c := &Capture{t: t}
+	c.Track()
+	return c
+}
+
+// Track captures the lines logged from the calling goroutine.
+func (c *Capture) Track() {
+	captures.mu.Lock()
+	defer captures.mu.Unlock()
+	if len(captures.goroutines) == 0 {
+		captures.goroutines = make(map[uint64]*Capture)
+		klog.AddSink(sink{})
+	}
+	captures.goroutines[goroutineID()] = c
+	atomic.StoreInt32(&captures.tracked, int32(len(captures.goroutines)))
+}
+
+// Go runs f in a new goroutine whose lines are captured.
+func (c *Capture) Go(f func()) {
+	go func() {
+		c.Track()
+		defer c.untrack(goroutineID())
+		f()
+	}()
+}
+
+// Stop stops capturing the lines of all goroutines of c and of its Logger.
+// The captured lines remain available.
+func (c *Capture) Stop() {
+	captures.mu.Lock()
+	for id, d := range captures.goroutines {
+		if d == c {
+			delete(captures.goroutines, id)
+		}
+	}
+	atomic.StoreInt32(&captures.tracked, int32(len(captures.goroutines)))
+	if len(captures.goroutines) == 0 {
+		klog.RemoveSink(sink{})
+	}
+	captures.mu.Unlock()
+
+	c.mu.Lock()
+	c.stopped = true
+	c.mu.Unlock()
+}
+
+// untrack stops capturing the lines of a goroutine.
+func (c *Capture) untrack(id uint64) {
+	captures.mu.Lock()
+	defer captures.mu.Unlock()
+	if captures.goroutines[id] == c {
+		delete(captures.goroutines, id)
+	}
+	atomic.StoreInt32(&captures.tracked, int32(len(captures.goroutines)))
+	if len(captures.goroutines) == 0 {
+		klog.RemoveSink(sink{})
+	}
+}
+
+// log captures a line and passes it to t.Log.
+func (c *Capture) log(e *klog.Entry) {
+	s := severityIndex(e.Severity)
+	if s < 0 {
+		return
+	}
+	formatted := string(klog.GlogFormatter{}.Format(e))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return
+	}
+	c.lines = append(c.lines, Line{Severity: e.Severity, Text: e.Text(), Formatted: formatted})
+	for i := s; i >= 0; i-- {
+		c.buffers[i].WriteString(formatted)
+	}
+	c.t.Log(strings.TrimSuffix(formatted, "\n"))
+}
+
+// Lines returns the captured lines.
+func (c *Capture) Lines() []Line {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Line(nil), c.lines...)
+}
+
+// Contents returns the captured lines of severity and higher, in the glog
+// format, like the log file of that severity would contain them.
+func (c *Capture) Contents(severity string) string {
+	s := severityIndex(severity)
+	if s < 0 {
+		panic(fmt.Sprintf("ktesting: unknown severity %q", severity))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buffers[s].String()
+}
+
+// matches reports whether a line of severity or higher matches re.
+func (c *Capture) matches(severity, re string) bool {
+	s := severityIndex(severity)
+	if s < 0 {
+		panic(fmt.Sprintf("ktesting: unknown severity %q", severity))
+	}
+	r := regexp.MustCompile(re)
+	for _, line := range c.Lines() {
+		if severityIndex(line.Severity) >= s && r.MatchString(line.Text) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpectLogged reports a test error unless the message of a captured line of
+// severity or higher matches the regular expression re.
+func (c *Capture) ExpectLogged(severity, re string) bool {
+	c.t.Helper()
+	if c.matches(severity, re) {
+		return true
+	}
+	c.t.Errorf("no %s line matching %q was logged, got:\n%s", severity, re, c.Contents("INFO"))
+	return false
+}
+
+// ExpectNotLogged reports a test error if the message of a captured line of
+// severity or higher matches the regular expression re.
+func (c *Capture) ExpectNotLogged(severity, re string) bool {
+	c.t.Helper()
+	if !c.matches(severity, re) {
+		return true
+	}
+	c.t.Errorf("a %s line matching %q was logged, got:\n%s", severity, re, c.Contents("INFO"))
+	return false
+}
+
+// Logger returns a logr.Logger that logs into c, from any goroutine, until
+// Stop is called. Its lines are not written to the klog outputs. Like klogr,
+// it logs V lines only if klog.V enables their level, and like klog it
+// redacts, truncates and handles multi-line messages, see klog.PrepareEntry.
+func (c *Capture) Logger() logr.Logger {
+	return logger{c: c}
+}
+
+// logger is the logr.Logger of a Capture.
+type logger struct {
+	c      *Capture
+	level  int
+	name   string
+	values []interface{}
+}
+
+func (l logger) entry(severity string, err error, msg string, kvList []interface{}) *klog.Entry {
+	e := &klog.Entry{
+		Severity:   severity,
+		Time:       klog.Now(),
+		Logger:     l.name,
+		Message:    msg,
+		Err:        err,
+		Structured: true,
+	}
+	e.KeysAndValues = append(append(e.KeysAndValues, l.values...), kvList...)
+	klog.PrepareEntry(e)
+	if _, file, line, ok := runtime.Caller(2); ok {
+		e.File, e.Line = file[strings.LastIndex(file, "/")+1:], line
+	}
+	return e
+}
+
+func (l logger) Info(msg string, kvList ...interface{}) {
+	if l.Enabled() {
+		l.c.log(l.entry("INFO", nil, msg, kvList))
+	}
+}
+
+func (l logger) Enabled() bool {
+	return bool(klog.V(klog.Level(l.level)))
+}
+
+func (l logger) Error(err error, msg string, kvList ...interface{}) {
+	l.c.log(l.entry("ERROR", err, msg, kvList))
+}
+
+func (l logger) V(level int) logr.InfoLogger {
+	l.level = level
+	return l
+}
+
+func (l logger) WithName(name string) logr.Logger {
+	if l.name != "" {
+		name = l.name + "/" + name
+	}
+	l.name = name
+	return l
+}
+
+func (l logger) WithValues(kvList ...interface{}) logr.Logger {
+	l.values = append(l.values[:len(l.values):len(l.values)], kvList...)
+	return l
+}
+
+var _ logr.Logger = logger{}
+
+// goroutineID returns the ID of the calling goroutine, from the first line
+// of its stack trace, "goroutine 18 [running]:".
+func goroutineID() uint64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
@@ -0,0 +1,164 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ktesting_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/klog"
+	"k8s.io/klog/klogr"
+	. "k8s.io/klog/ktesting"
+)
+
+// fakeTB records what a Capture reports.
+type fakeTB struct {
+	mu     sync.Mutex
+	logs   []string
+	errors []string
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Log(args ...interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.logs = append(f.logs, fmt.Sprint(args...))
+}
+
+func (f *fakeTB) Errorf(format string, args ...interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func TestCapture(t *testing.T) {
+	tb := new(fakeTB)
+	c := New(tb)
+	klog.Info("hello")
+	klog.Warningf("disk %d%% full", 91)
+	klog.Error("oops")
+	c.Stop()
+	klog.Info("after stop")
+
+	if got := len(c.Lines()); got != 3 {
+		t.Fatalf("got %d lines, want 3: %v", got, c.Lines())
+	}
+	if len(tb.logs) != 3 || !strings.HasSuffix(tb.logs[0], "] hello") {
+		t.Errorf("lines were not passed to t.Log: %q", tb.logs)
+	}
+	if contents := c.Contents("WARNING"); strings.Contains(contents, "hello") || !strings.Contains(contents, "disk 91% full") || !strings.Contains(contents, "oops") {
+		t.Errorf("wrong WARNING contents: %q", contents)
+	}
+	if !c.ExpectLogged("WARNING", `disk \d+% full`) || !c.ExpectLogged("INFO", "oops") || !c.ExpectNotLogged("INFO", "after stop") {
+		t.Errorf("unexpected failures: %q", tb.errors)
+	}
+	if len(tb.errors) != 0 {
+		t.Fatalf("unexpected errors: %q", tb.errors)
+	}
+	if c.ExpectLogged("ERROR", "hello") || c.ExpectNotLogged("ERROR", "oops") {
+		t.Error("expectations did not fail")
+	}
+	if len(tb.errors) != 2 {
+		t.Errorf("got %d errors, want 2: %q", len(tb.errors), tb.errors)
+	}
+}
+
+func TestCaptureGoroutines(t *testing.T) {
+	a := New(t)
+	defer a.Stop()
+
+	done := make(chan struct{})
+	a.Go(func() {
+		klog.Info("from a")
+		close(done)
+	})
+	<-done
+
+	// A goroutine of another test.
+	ready := make(chan *Capture)
+	go func() {
+		c := New(new(fakeTB))
+		klog.Info("from b")
+		ready <- c
+	}()
+	b := <-ready
+	b.Stop()
+
+	klog.Info("from test")
+	a.ExpectLogged("INFO", "from a")
+	a.ExpectLogged("INFO", "from test")
+	a.ExpectNotLogged("INFO", "from b")
+	b.ExpectLogged("INFO", "from b")
+	b.ExpectNotLogged("INFO", "from a|from test")
+}
+
+func TestLogger(t *testing.T) {
+	c := New(t)
+	defer c.Stop()
+	logger := c.Logger().WithName("sync").WithValues("pod", "kubedns")
+	ctx := klogr.NewContext(context.Background(), logger)
+
+	done := make(chan struct{})
+	// An untracked goroutine.
+	go func() {
+		klogr.FromContext(ctx).Error(errors.New("timeout"), "sync failed", "attempt", 3)
+		close(done)
+	}()
+	<-done
+
+	lines := c.Lines()
+	if len(lines) != 1 {
+		t.Fatalf("got %d lines, want 1: %v", len(lines), lines)
+	}
+	want := `"sync failed" err="timeout" logger="sync" pod="kubedns" attempt=3`
+	if lines[0].Severity != "ERROR" || lines[0].Text != want {
+		t.Errorf("got %+v, want ERROR line %s", lines[0], want)
+	}
+	if !strings.Contains(lines[0].Formatted, "ktesting_test.go:") {
+		t.Errorf("wrong caller: %s", lines[0].Formatted)
+	}
+}
+
+func TestLoggerClock(t *testing.T) {
+	klog.SetClock(klog.ClockFunc(func() time.Time { return time.Date(2020, time.October, 16, 12, 0, 0, 0, time.Local) }))
+	defer klog.SetClock(nil)
+	c := New(t)
+	defer c.Stop()
+
+	c.Logger().Info("tick")
+	if lines := c.Lines(); len(lines) != 1 || !strings.HasPrefix(lines[0].Formatted, "I1016 12:00:00.000000 ") {
+		t.Errorf("line does not have the time of the klog clock: %v", lines)
+	}
+}
+
+func TestLoggerRedaction(t *testing.T) {
+	klog.SetRedactors(klog.RegexpRedactor(regexp.MustCompile(`hunter2`), klog.Redacted))
+	defer klog.SetRedactors()
+	c := New(t)
+	defer c.Stop()
+
+	c.Logger().Error(errors.New("bad hunter2"), "login with hunter2", "input", "hunter2")
+	want := `"login with [REDACTED]" err="bad [REDACTED]" input="[REDACTED]"`
+	if lines := c.Lines(); len(lines) != 1 || lines[0].Text != want {
+		t.Errorf("got %v, want a line %s", lines, want)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics publishes the statistics of k8s.io/klog in the Prometheus
+// text exposition format.
+//
+// Usage:
+//
+//	http.Handle("/metrics/klog", metrics.Handler())
+//
+// The handler does not depend on the Prometheus client library. Programs that
+// already expose metrics with it can merge the output, or scrape the handler
+// as a separate target.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"k8s.io/klog"
+)
+
+// contentType is the content type of the text exposition format.
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler returns an http.Handler that serves the current klog statistics.
+func Handler() http.Handler {
+	return handler{
+		snapshot:  klog.ExtendedStats.Snapshot,
+		verbosity: klog.Verbosity,
+	}
+}
+
+type handler struct {
+	snapshot  func() klog.StatsSnapshot
+	verbosity func() klog.Level
+}
+
+func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	write(w, h.snapshot(), h.verbosity())
+}
+
+// metric describes one metric family.
+type metric struct {
+	name, help, typ string
+	// value returns the value for a severity.
+	value func(klog.SeverityStats) int64
+}
+
+var severityMetrics = []metric{
+	{"klog_lines_total", "Number of log lines written.", "counter",
+		func(s klog.SeverityStats) int64 { return s.Lines }},
+	{"klog_bytes_total", "Number of bytes of log lines written.", "counter",
+		func(s klog.SeverityStats) int64 { return s.Bytes }},
+	{"klog_dropped_lines_total", "Number of log lines dropped by the asynchronous output.", "counter",
+		func(s klog.SeverityStats) int64 { return s.DroppedLines }},
+	{"klog_dropped_bytes_total", "Number of bytes of log lines dropped by the asynchronous output.", "counter",
+		func(s klog.SeverityStats) int64 { return s.DroppedBytes }},
+	{"klog_suppressed_lines_total", "Number of log lines suppressed by rate limits.", "counter",
+		func(s klog.SeverityStats) int64 { return s.SuppressedLines }},
+	{"klog_write_errors_total", "Number of failed writes to outputs.", "counter",
+		func(s klog.SeverityStats) int64 { return s.WriteErrors }},
+	{"klog_log_file_rotations_total", "Number of log file rotations.", "counter",
+		func(s klog.SeverityStats) int64 { return s.Rotations }},
+}
+
+// write writes the metrics in the text exposition format.
+func write(w io.Writer, snapshot klog.StatsSnapshot, verbosity klog.Level) error {
+	bw := bufio.NewWriter(w)
+	severities := []struct {
+		name  string
+		stats klog.SeverityStats
+	}{
+		{"info", snapshot.Info},
+		{"warning", snapshot.Warning},
+		{"error", snapshot.Error},
+		{"fatal", snapshot.Fatal},
+	}
+	for _, m := range severityMetrics {
+		header(bw, m.name, m.help, m.typ)
+		for _, s := range severities {
+			fmt.Fprintf(bw, "%s{severity=%q} %d\n", m.name, s.name, m.value(s.stats))
+		}
+	}
+
+	header(bw, "klog_v_evaluated_total", "Number of calls to V per level.", "counter")
+	for level, v := range snapshot.V {
+		if v.Evaluated > 0 {
+			fmt.Fprintf(bw, "klog_v_evaluated_total{level=%q} %d\n", strconv.Itoa(level), v.Evaluated)
+		}
+	}
+	header(bw, "klog_v_enabled_total", "Number of calls to V per level that returned true.", "counter")
+	for level, v := range snapshot.V {
+		if v.Evaluated > 0 {
+			fmt.Fprintf(bw, "klog_v_enabled_total{level=%q} %d\n", strconv.Itoa(level), v.Enabled)
+		}
+	}
+	header(bw, "klog_v_logged_total", "Number of log lines written through V per level.", "counter")
+	for level, v := range snapshot.V {
+		if v.Evaluated > 0 {
+			fmt.Fprintf(bw, "klog_v_logged_total{level=%q} %d\n", strconv.Itoa(level), v.Logged)
+		}
+	}
+	header(bw, "klog_v_enabled_by_vmodule_total", "Number of calls to V per level that returned true because of -vmodule.", "counter")
+	for level, v := range snapshot.V {
+		if v.Evaluated > 0 {
+			fmt.Fprintf(bw, "klog_v_enabled_by_vmodule_total{level=%q} %d\n", strconv.Itoa(level), v.EnabledByVmodule)
+		}
+	}
+
+	header(bw, "klog_verbosity", "The current V logging level.", "gauge")
+	fmt.Fprintf(bw, "klog_verbosity %d\n", verbosity)
+	return bw.Flush()
+}
+
+func header(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
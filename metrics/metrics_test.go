@@ -0,0 +1,76 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/klog"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestHandler(t *testing.T) {
+	snapshot := klog.StatsSnapshot{
+		Info:    klog.SeverityStats{Lines: 10, Bytes: 1000, DroppedLines: 2, DroppedBytes: 200, Rotations: 1},
+		Warning: klog.SeverityStats{Lines: 5, Bytes: 500, WriteErrors: 1},
+		Error:   klog.SeverityStats{Lines: 1, Bytes: 100, SuppressedLines: 42},
+		V:       make([]klog.VStats, 16),
+	}
+	snapshot.V[0] = klog.VStats{Evaluated: 7, Enabled: 7, Logged: 6}
+	snapshot.V[4] = klog.VStats{Evaluated: 3, Enabled: 1, EnabledByVmodule: 1, Logged: 1}
+	h := handler{
+		snapshot:  func() klog.StatsSnapshot { return snapshot },
+		verbosity: func() klog.Level { return 2 },
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if got := rec.Header().Get("Content-Type"); got != contentType {
+		t.Errorf("got content type %q, expected %q", got, contentType)
+	}
+
+	golden := filepath.Join("testdata", "metrics.golden")
+	if *update {
+		if err := ioutil.WriteFile(golden, rec.Body.Bytes(), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := rec.Body.String(); got != string(expected) {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestHandlerLive(t *testing.T) {
+	flagset := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(flagset)
+	flagset.Set("v", "3")
+	defer flagset.Set("v", "0")
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if got, expected := rec.Body.String(), "\nklog_verbosity 3\n"; !strings.Contains(got, expected) {
+		t.Errorf("got:\n%s\nexpected it to contain %q", got, expected)
+	}
+}
@@ -0,0 +1,416 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package otlp exports the lines of k8s.io/klog and klogr as OpenTelemetry
+// log records, with OTLP/HTTP in the protocol buffers encoding.
+//
+// Usage:
+//
+//	exporter := otlp.New(otlp.Options{Endpoint: "http://collector:4318/v1/logs"})
+//	klog.AddSink(exporter)
+//	klog.OnFatal(func(klog.FatalInfo) { exporter.Shutdown(context.Background()) })
+//	defer exporter.Shutdown(context.Background())
+//
+// A record has the severity of the line, the message as body, the key/value
+// pairs of InfoS, ErrorS and klogr as attributes, and the caller in the
+// attributes code.filepath, code.lineno and code.function. The IDs of
+// klog.TraceValues become the trace and span IDs of the record. Records are sent
+// in batches by a background goroutine, which retries failed requests. The
+// gRPC transport of OTLP is not supported, as it would need dependencies
+// that this module avoids; collectors accept OTLP/HTTP on port 4318.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// DefaultEndpoint is the logs endpoint of a local collector.
+const DefaultEndpoint = "http://localhost:4318/v1/logs"
+
+// Options configures an Exporter. Zero values select the defaults.
+type Options struct {
+	// Endpoint is the URL that requests are posted to, DefaultEndpoint
+	// by default.
+	Endpoint string
+	// Headers are added to each request, for example for authentication.
+	Headers map[string]string
+	// Resource holds the attributes of the resource that logs, by default
+	// service.name with the name of the program.
+	Resource map[string]string
+	// BatchSize is the maximum number of records per request, 512 by
+	// default.
+	BatchSize int
+	// BatchTimeout is how long a record may wait for a batch to fill up,
+	// one second by default.
+	BatchTimeout time.Duration
+	// QueueSize is the maximum number of records waiting to be sent, 2048
+	// by default. Records that do not fit are dropped.
+	QueueSize int
+	// MaxRetries is how often a failed request is retried, 5 by default.
+	// Negative values disable retries.
+	MaxRetries int
+	// RetryBackoff is the time before the first retry, which doubles for
+	// each further retry, 500 milliseconds by default.
+	RetryBackoff time.Duration
+	// Client sends the requests, http.DefaultClient by default.
+	Client *http.Client
+}
+
+// severityNumbers are the OpenTelemetry severity numbers of the klog
+// severities.
+var severityNumbers = map[string]uint64{"INFO": 9, "WARNING": 13, "ERROR": 17, "FATAL": 21}
+
+// An Exporter sends log records to an OTLP receiver. It implements
+// klog.Sink.
+type Exporter struct {
+	opts     Options
+	resource buffer
+
+	queue   chan buffer
+	flushes chan chan struct{}
+	// mu is held for reading while a record is queued and for writing
+	// while stop is closed, so that no record is queued after run has
+	// drained the queue.
+	mu       sync.RWMutex
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+
+	dropped int64
+	failed  int64
+}
+
+// New returns an Exporter, which starts sending records in the background.
+func New(opts Options) *Exporter {
+	if opts.Endpoint == "" {
+		opts.Endpoint = DefaultEndpoint
+	}
+	if opts.Resource == nil {
+		opts.Resource = map[string]string{"service.name": filepath.Base(os.Args[0])}
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 512
+	}
+	if opts.BatchTimeout <= 0 {
+		opts.BatchTimeout = time.Second
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 2048
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = 5
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = 500 * time.Millisecond
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	x := &Exporter{
+		opts:    opts,
+		queue:   make(chan buffer, opts.QueueSize),
+		flushes: make(chan chan struct{}),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	x.resource.message(resourceLogsResource, func(r *buffer) {
+		for _, k := range sortedKeys(opts.Resource) {
+			r.keyValue(resourceAttributes, k, opts.Resource[k])
+		}
+	})
+	go x.run()
+	return x
+}
+
+// Log queues the record of an entry, or drops it if the queue is full. The
+// observed time of the record is the time of the klog clock.
+func (x *Exporter) Log(e *klog.Entry) {
+	record := encodeRecord(e, klog.Now())
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	select {
+	case <-x.stop:
+		atomic.AddInt64(&x.dropped, 1)
+		return
+	default:
+	}
+	select {
+	case x.queue <- record:
+	default:
+		atomic.AddInt64(&x.dropped, 1)
+	}
+}
+
+// Dropped returns the number of records that were dropped because the queue
+// was full or the exporter was shut down.
+func (x *Exporter) Dropped() int64 {
+	return atomic.LoadInt64(&x.dropped)
+}
+
+// Failed returns the number of records that could not be sent.
+func (x *Exporter) Failed() int64 {
+	return atomic.LoadInt64(&x.failed)
+}
+
+// Flush sends the queued records and waits until they have been sent or
+// ctx is done.
+func (x *Exporter) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case x.flushes <- done:
+	case <-x.stopped:
+		return errors.New("otlp: exporter is shut down")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown sends the queued records and stops the exporter, waiting until
+// it has stopped or ctx is done. Records logged afterwards are dropped.
+func (x *Exporter) Shutdown(ctx context.Context) error {
+	x.stopOnce.Do(func() {
+		x.mu.Lock()
+		close(x.stop)
+		x.mu.Unlock()
+	})
+	select {
+	case <-x.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run collects the queued records into batches and sends them.
+func (x *Exporter) run() {
+	defer close(x.stopped)
+	var batch []buffer
+	timer := time.NewTimer(x.opts.BatchTimeout)
+	defer timer.Stop()
+	// drain adds the queued records to the batch, sending full batches.
+	drain := func() {
+		for {
+			select {
+			case record := <-x.queue:
+				if batch = append(batch, record); len(batch) >= x.opts.BatchSize {
+					x.export(batch)
+					batch = nil
+				}
+			default:
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case record := <-x.queue:
+			if batch = append(batch, record); len(batch) >= x.opts.BatchSize {
+				x.export(batch)
+				batch = nil
+			}
+		case <-timer.C:
+			if len(batch) > 0 {
+				x.export(batch)
+				batch = nil
+			}
+			timer.Reset(x.opts.BatchTimeout)
+		case done := <-x.flushes:
+			drain()
+			if len(batch) > 0 {
+				x.export(batch)
+				batch = nil
+			}
+			close(done)
+		case <-x.stop:
+			drain()
+			if len(batch) > 0 {
+				x.export(batch)
+			}
+			return
+		}
+	}
+}
+
+// export sends a batch of records, retrying with a growing backoff while the
+// receiver is unreachable or asks to retry.
+func (x *Exporter) export(batch []buffer) {
+	body := x.encodeRequest(batch)
+	backoff := x.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := x.post(body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= x.opts.MaxRetries {
+			atomic.AddInt64(&x.failed, int64(len(batch)))
+			fmt.Fprintf(os.Stderr, "otlp: dropping %d log records: %v\n", len(batch), err)
+			return
+		}
+		select {
+		case <-time.After(backoff):
+		case <-x.stop:
+			// Try once more without waiting when shutting down.
+			if _, err := x.post(body); err != nil {
+				atomic.AddInt64(&x.failed, int64(len(batch)))
+			}
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// post posts a request and reports whether it may be retried if it fails.
+func (x *Exporter) post(body []byte) (retry bool, err error) {
+	req, err := http.NewRequest("POST", x.opts.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range x.opts.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := x.opts.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusBadGateway,
+		resp.StatusCode == http.StatusServiceUnavailable, resp.StatusCode == http.StatusGatewayTimeout:
+		return true, errors.New(resp.Status)
+	default:
+		return false, errors.New(resp.Status)
+	}
+}
+
+// encodeRequest encodes an ExportLogsServiceRequest with a batch of records.
+func (x *Exporter) encodeRequest(batch []buffer) []byte {
+	var b buffer
+	b.message(requestResourceLogs, func(rl *buffer) {
+		*rl = append(*rl, x.resource...)
+		rl.message(resourceLogsScopeLogs, func(sl *buffer) {
+			sl.message(scopeLogsScope, func(s *buffer) { s.string(scopeName, "k8s.io/klog") })
+			for _, record := range batch {
+				sl.bytes(scopeLogsLogRecords, record)
+			}
+		})
+	})
+	return b
+}
+
+// encodeRecord encodes the fields of the LogRecord of an entry that was
+// received at observed.
+func encodeRecord(e *klog.Entry, observed time.Time) buffer {
+	var b buffer
+	b.fixed64(recordTimeUnixNano, unixNano(e.Time))
+	b.fixed64(recordObservedTimeUnixNano, unixNano(observed))
+	if n, ok := severityNumbers[e.Severity]; ok {
+		b.uint(recordSeverityNumber, n)
+	}
+	b.string(recordSeverityText, e.Severity)
+	body := e.Message
+	if !e.Structured {
+		body = e.Text()
+	}
+	b.message(recordBody, func(a *buffer) { a.string(anyString, trimNewline(body)) })
+	if e.File != "" {
+		b.keyValue(recordAttributes, "code.filepath", e.File)
+		b.keyValue(recordAttributes, "code.lineno", e.Line)
+	}
+	if e.Function != "" {
+		b.keyValue(recordAttributes, "code.function", e.Function)
+	}
+	if e.Logger != "" {
+		b.keyValue(recordAttributes, klog.LoggerKey, e.Logger)
+	}
+	if e.Err != nil {
+		b.keyValue(recordAttributes, "exception.message", e.Err.Error())
+	}
+	for i := 0; i < len(e.KeysAndValues); i += 2 {
+		var v interface{} = "(MISSING)"
+		if i+1 < len(e.KeysAndValues) {
+			v = e.KeysAndValues[i+1]
+		}
+		key := fmt.Sprint(e.KeysAndValues[i])
+		// The IDs of klog.TraceValues go into the fields of the record.
+		if id, ok := traceID(key, v); ok {
+			field := recordTraceID
+			if key == klog.SpanIDKey {
+				field = recordSpanID
+			}
+			b.bytes(field, id)
+			continue
+		}
+		b.keyValue(recordAttributes, key, v)
+	}
+	return b
+}
+
+// traceID returns the bytes of a trace or span ID in a key/value pair with
+// klog.TraceIDKey or klog.SpanIDKey, if the value is a valid hexadecimal ID.
+func traceID(key string, v interface{}) ([]byte, bool) {
+	size := 16
+	switch key {
+	case klog.TraceIDKey:
+	case klog.SpanIDKey:
+		size = 8
+	default:
+		return nil, false
+	}
+	s, ok := v.(string)
+	if !ok {
+		return nil, false
+	}
+	id, err := hex.DecodeString(s)
+	if err != nil || len(id) != size {
+		return nil, false
+	}
+	return id, true
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var _ klog.Sink = &Exporter{}
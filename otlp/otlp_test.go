@@ -0,0 +1,437 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlp
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// message is a decoded protocol buffers message: the values of each field,
+// which are uint64 for numbers and []byte for strings and messages.
+type message map[int][]interface{}
+
+func decode(data []byte) (message, error) {
+	m := make(message)
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errors.New("bad tag")
+		}
+		data = data[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case wireVarint:
+			v, n := binary.Uvarint(data)
+			if n <= 0 {
+				return nil, errors.New("bad varint")
+			}
+			m[field] = append(m[field], v)
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return nil, errors.New("bad fixed64")
+			}
+			m[field] = append(m[field], binary.LittleEndian.Uint64(data))
+			data = data[8:]
+		case wireBytes:
+			l, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < l {
+				return nil, errors.New("bad length")
+			}
+			m[field] = append(m[field], data[n:n+int(l)])
+			data = data[n+int(l):]
+		default:
+			return nil, fmt.Errorf("unexpected wire type %d", key&7)
+		}
+	}
+	return m, nil
+}
+
+func (m message) sub(t *testing.T, field int) []message {
+	var subs []message
+	for _, v := range m[field] {
+		sub, err := decode(v.([]byte))
+		if err != nil {
+			t.Fatalf("field %d: %v", field, err)
+		}
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// anyValue returns the value of an AnyValue.
+func anyValue(t *testing.T, m message) interface{} {
+	switch {
+	case m[anyString] != nil:
+		return string(m[anyString][0].([]byte))
+	case m[anyBool] != nil:
+		return m[anyBool][0].(uint64) == 1
+	case m[anyInt] != nil:
+		return int64(m[anyInt][0].(uint64))
+	case m[anyDouble] != nil:
+		return math.Float64frombits(m[anyDouble][0].(uint64))
+	case m[anyArray] != nil:
+		var values []interface{}
+		for _, v := range m.sub(t, anyArray)[0].sub(t, arrayValues) {
+			values = append(values, anyValue(t, v))
+		}
+		return values
+	}
+	return nil
+}
+
+// attributes returns the attributes in a field of KeyValues.
+func attributes(t *testing.T, m message, field int) map[string]interface{} {
+	attrs := make(map[string]interface{})
+	for _, kv := range m.sub(t, field) {
+		attrs[string(kv[keyValueKey][0].([]byte))] = anyValue(t, kv.sub(t, keyValueValue)[0])
+	}
+	return attrs
+}
+
+// record is a decoded LogRecord.
+type record struct {
+	time       time.Time
+	observed   time.Time
+	severity   uint64
+	text       string
+	body       interface{}
+	attributes map[string]interface{}
+	traceID    []byte
+	spanID     []byte
+}
+
+// receiver is an in-process stand-in for an OTLP receiver.
+type receiver struct {
+	t        *testing.T
+	server   *httptest.Server
+	mu       sync.Mutex
+	requests []*http.Request
+	resource []map[string]interface{}
+	batches  [][]record
+	// status returns the status of the next response.
+	status func(n int) int
+}
+
+func newReceiver(t *testing.T) *receiver {
+	r := &receiver{t: t, status: func(int) int { return http.StatusOK }}
+	r.server = httptest.NewServer(r)
+	return r
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	if status := r.status(len(r.requests)); status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	m, err := decode(body)
+	if err != nil {
+		r.t.Errorf("unexpected error: %v", err)
+		return
+	}
+	for _, rl := range m.sub(r.t, requestResourceLogs) {
+		r.resource = append(r.resource, attributes(r.t, rl.sub(r.t, resourceLogsResource)[0], resourceAttributes))
+		for _, sl := range rl.sub(r.t, resourceLogsScopeLogs) {
+			if name := string(sl.sub(r.t, scopeLogsScope)[0][scopeName][0].([]byte)); name != "k8s.io/klog" {
+				r.t.Errorf("unexpected scope %q", name)
+			}
+			var batch []record
+			for _, lr := range sl.sub(r.t, scopeLogsLogRecords) {
+				rec := record{
+					time:       time.Unix(0, int64(lr[recordTimeUnixNano][0].(uint64))),
+					observed:   time.Unix(0, int64(lr[recordObservedTimeUnixNano][0].(uint64))),
+					severity:   lr[recordSeverityNumber][0].(uint64),
+					text:       string(lr[recordSeverityText][0].([]byte)),
+					body:       anyValue(r.t, lr.sub(r.t, recordBody)[0]),
+					attributes: attributes(r.t, lr, recordAttributes),
+				}
+				if ids := lr[recordTraceID]; ids != nil {
+					rec.traceID = ids[0].([]byte)
+				}
+				if ids := lr[recordSpanID]; ids != nil {
+					rec.spanID = ids[0].([]byte)
+				}
+				batch = append(batch, rec)
+			}
+			r.batches = append(r.batches, batch)
+		}
+	}
+}
+
+func (r *receiver) received() ([][]record, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.batches, len(r.requests)
+}
+
+func TestExporter(t *testing.T) {
+	r := newReceiver(t)
+	defer r.server.Close()
+	x := New(Options{Endpoint: r.server.URL, Resource: map[string]string{"service.name": "test"}})
+	defer x.Shutdown(context.Background())
+
+	flagset := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(flagset)
+	flagset.Set("logtostderr", "false")
+	flagset.Set("stderrthreshold", "FATAL")
+	defer flagset.Set("logtostderr", "true")
+	defer flagset.Set("stderrthreshold", "ERROR")
+	klog.SetOutput(ioutil.Discard)
+	defer klog.SetOutput(nil)
+	klog.AddSink(x)
+	defer klog.RemoveSink(x)
+
+	klog.ErrorS(errors.New("timeout"), "Sync failed", "pod", "kubedns", "attempt", 3, "ratio", 0.5,
+		"ready", false, "tags", []string{"a", "b"})
+	_, _, line, _ := runtime.Caller(0)
+	klog.Info("plain\n")
+	if err := x.Flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	batches, requests := r.received()
+	if requests != 1 || len(batches) != 1 || len(batches[0]) != 2 {
+		t.Fatalf("expected one batch of two records, got %d requests with %+v", requests, batches)
+	}
+	if got := r.resource[0]; !reflect.DeepEqual(got, map[string]interface{}{"service.name": "test"}) {
+		t.Errorf("unexpected resource %v", got)
+	}
+	if got := r.requests[0].Header.Get("Content-Type"); got != "application/x-protobuf" {
+		t.Errorf("unexpected content type %q", got)
+	}
+	rec := batches[0][0]
+	if rec.severity != 17 || rec.text != "ERROR" || rec.body != "Sync failed" || time.Since(rec.time) > time.Minute {
+		t.Errorf("unexpected record %+v", rec)
+	}
+	expected := map[string]interface{}{
+		"code.filepath":     "otlp_test.go",
+		"code.lineno":       int64(line - 2),
+		"code.function":     "k8s.io/klog/otlp.TestExporter",
+		"exception.message": "timeout",
+		"pod":               "kubedns",
+		"attempt":           int64(3),
+		"ratio":             0.5,
+		"ready":             false,
+		"tags":              []interface{}{"a", "b"},
+	}
+	if !reflect.DeepEqual(rec.attributes, expected) {
+		t.Errorf("got attributes %v, expected %v", rec.attributes, expected)
+	}
+	if rec := batches[0][1]; rec.severity != 9 || rec.body != "plain" {
+		t.Errorf("unexpected record %+v", rec)
+	}
+}
+
+func TestBatches(t *testing.T) {
+	r := newReceiver(t)
+	defer r.server.Close()
+	x := New(Options{Endpoint: r.server.URL, BatchSize: 2, BatchTimeout: time.Hour})
+
+	for i := 0; i < 5; i++ {
+		x.Log(&klog.Entry{Severity: "INFO", Message: fmt.Sprint(i)})
+	}
+	if err := x.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	x.Log(&klog.Entry{Severity: "INFO", Message: "after shutdown"})
+
+	batches, _ := r.received()
+	var sizes []int
+	for _, batch := range batches {
+		sizes = append(sizes, len(batch))
+	}
+	if !reflect.DeepEqual(sizes, []int{2, 2, 1}) {
+		t.Errorf("got batches of %v records, expected 2, 2 and 1", sizes)
+	}
+	if x.Dropped() != 1 {
+		t.Errorf("expected 1 dropped record, got %d", x.Dropped())
+	}
+}
+
+func TestShutdownInProgress(t *testing.T) {
+	r := newReceiver(t)
+	defer r.server.Close()
+	release := make(chan struct{})
+	r.status = func(int) int {
+		<-release
+		return http.StatusOK
+	}
+	x := New(Options{Endpoint: r.server.URL, BatchSize: 1})
+
+	x.Log(&klog.Entry{Severity: "INFO", Message: "sending"})
+	// The exporter is stopping but still sending the first record.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := x.Shutdown(ctx); err == nil {
+		t.Fatal("Shutdown should not have waited for the receiver")
+	}
+	x.Log(&klog.Entry{Severity: "INFO", Message: "during shutdown"})
+	close(release)
+	if err := x.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	batches, _ := r.received()
+	if len(batches) != 1 || len(batches[0]) != 1 || batches[0][0].body != "sending" {
+		t.Errorf("expected only the first record to be sent, got %+v", batches)
+	}
+	if x.Dropped() != 1 {
+		t.Errorf("expected 1 dropped record, got %d", x.Dropped())
+	}
+}
+
+func TestObservedTime(t *testing.T) {
+	r := newReceiver(t)
+	defer r.server.Close()
+	x := New(Options{Endpoint: r.server.URL})
+	defer x.Shutdown(context.Background())
+	logged := time.Date(2020, time.October, 16, 12, 0, 0, 0, time.UTC)
+	received := logged.Add(time.Second)
+	klog.SetClock(klog.ClockFunc(func() time.Time { return received }))
+	defer klog.SetClock(nil)
+
+	x.Log(&klog.Entry{Severity: "INFO", Time: logged, Message: "late"})
+	if err := x.Flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	batches, _ := r.received()
+	if len(batches) != 1 || len(batches[0]) != 1 {
+		t.Fatalf("expected one record, got %+v", batches)
+	}
+	if rec := batches[0][0]; !rec.time.Equal(logged) || !rec.observed.Equal(received) {
+		t.Errorf("got time %v and observed time %v, expected %v and %v", rec.time, rec.observed, logged, received)
+	}
+}
+
+func TestBatchTimeout(t *testing.T) {
+	r := newReceiver(t)
+	defer r.server.Close()
+	x := New(Options{Endpoint: r.server.URL, BatchTimeout: 10 * time.Millisecond})
+	defer x.Shutdown(context.Background())
+
+	x.Log(&klog.Entry{Severity: "INFO", Message: "waiting"})
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if batches, _ := r.received(); len(batches) == 1 {
+			return
+		}
+	}
+	t.Error("the record was not sent after the batch timeout")
+}
+
+func TestRetry(t *testing.T) {
+	r := newReceiver(t)
+	defer r.server.Close()
+	r.status = func(n int) int {
+		if n < 3 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	}
+	x := New(Options{Endpoint: r.server.URL, RetryBackoff: time.Millisecond})
+	defer x.Shutdown(context.Background())
+
+	x.Log(&klog.Entry{Severity: "WARNING", Message: "retried"})
+	if err := x.Flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if batches, requests := r.received(); requests != 3 || len(batches) != 1 || batches[0][0].body != "retried" {
+		t.Errorf("expected the record after 3 requests, got %d requests with %+v", requests, batches)
+	}
+
+	// Client errors are not retried.
+	r.mu.Lock()
+	r.status = func(int) int { return http.StatusBadRequest }
+	r.mu.Unlock()
+	x.Log(&klog.Entry{Severity: "WARNING", Message: "rejected"})
+	x.Flush(context.Background())
+	if _, requests := r.received(); requests != 4 || x.Failed() != 1 {
+		t.Errorf("expected 4 requests and 1 failed record, got %d and %d", requests, x.Failed())
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	r := newReceiver(t)
+	defer r.server.Close()
+	block := make(chan struct{})
+	r.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-block
+	})
+	x := New(Options{Endpoint: r.server.URL, BatchSize: 1, QueueSize: 2})
+	defer x.Shutdown(context.Background())
+	defer close(block)
+
+	// The first record is being sent, two are queued and the rest are
+	// dropped.
+	x.Log(&klog.Entry{Severity: "INFO", Message: "sending"})
+	for deadline := time.Now().Add(10 * time.Second); len(x.queue) > 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 5; i++ {
+		x.Log(&klog.Entry{Severity: "INFO", Message: "queued"})
+	}
+	if x.Dropped() != 3 {
+		t.Errorf("expected 3 dropped records, got %d", x.Dropped())
+	}
+}
+
+func TestTraceIDs(t *testing.T) {
+	r := newReceiver(t)
+	defer r.server.Close()
+	x := New(Options{Endpoint: r.server.URL})
+	defer x.Shutdown(context.Background())
+
+	x.Log(&klog.Entry{Severity: "INFO", Message: "traced", Structured: true, KeysAndValues: []interface{}{
+		klog.TraceIDKey, "4bf92f3577b34da6a3ce929d0e0e4736", klog.SpanIDKey, "00f067aa0ba902b7", "pod", "kubedns",
+	}})
+	x.Log(&klog.Entry{Severity: "INFO", Message: "invalid", Structured: true, KeysAndValues: []interface{}{
+		klog.TraceIDKey, "not hex",
+	}})
+	if err := x.Flush(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	batches, _ := r.received()
+	rec := batches[0][0]
+	if hex.EncodeToString(rec.traceID) != "4bf92f3577b34da6a3ce929d0e0e4736" || hex.EncodeToString(rec.spanID) != "00f067aa0ba902b7" {
+		t.Errorf("unexpected IDs %x and %x", rec.traceID, rec.spanID)
+	}
+	if !reflect.DeepEqual(rec.attributes, map[string]interface{}{"pod": "kubedns"}) {
+		t.Errorf("unexpected attributes %v", rec.attributes)
+	}
+	if rec := batches[0][1]; rec.traceID != nil || rec.attributes[klog.TraceIDKey] != "not hex" {
+		t.Errorf("invalid IDs should be kept as attributes, got %+v", rec)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Encoding of the OTLP messages in the protocol buffers wire format, which
+// avoids depending on the generated code of opentelemetry-proto.
+
+package otlp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// The wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// buffer encodes a message.
+type buffer []byte
+
+func (b *buffer) tag(field, wire int) {
+	b.varint(uint64(field)<<3 | uint64(wire))
+}
+
+func (b *buffer) varint(v uint64) {
+	for v >= 0x80 {
+		*b = append(*b, byte(v)|0x80)
+		v >>= 7
+	}
+	*b = append(*b, byte(v))
+}
+
+func (b *buffer) fixed64(field int, v uint64) {
+	b.tag(field, wireFixed64)
+	*b = append(*b, make([]byte, 8)...)
+	binary.LittleEndian.PutUint64((*b)[len(*b)-8:], v)
+}
+
+func (b *buffer) uint(field int, v uint64) {
+	b.tag(field, wireVarint)
+	b.varint(v)
+}
+
+func (b *buffer) bytes(field int, v []byte) {
+	b.tag(field, wireBytes)
+	b.varint(uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *buffer) string(field int, v string) {
+	b.tag(field, wireBytes)
+	b.varint(uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+// message encodes a nested message with the fields that f encodes.
+func (b *buffer) message(field int, f func(m *buffer)) {
+	var m buffer
+	f(&m)
+	b.bytes(field, m)
+}
+
+// The fields of the messages, from opentelemetry/proto/logs/v1/logs.proto
+// and opentelemetry/proto/common/v1/common.proto.
+const (
+	// ExportLogsServiceRequest
+	requestResourceLogs = 1
+	// ResourceLogs
+	resourceLogsResource  = 1
+	resourceLogsScopeLogs = 2
+	// Resource
+	resourceAttributes = 1
+	// ScopeLogs
+	scopeLogsScope      = 1
+	scopeLogsLogRecords = 2
+	// InstrumentationScope
+	scopeName = 1
+	// LogRecord
+	recordTimeUnixNano         = 1
+	recordSeverityNumber       = 2
+	recordSeverityText         = 3
+	recordBody                 = 5
+	recordAttributes           = 6
+	recordTraceID              = 9
+	recordSpanID               = 10
+	recordObservedTimeUnixNano = 11
+	// KeyValue
+	keyValueKey   = 1
+	keyValueValue = 2
+	// AnyValue
+	anyString = 1
+	anyBool   = 2
+	anyInt    = 3
+	anyDouble = 4
+	anyArray  = 5
+	anyBytes  = 7
+	// ArrayValue
+	arrayValues = 1
+)
+
+// keyValue encodes a KeyValue with an AnyValue for v.
+func (b *buffer) keyValue(field int, key string, v interface{}) {
+	b.message(field, func(m *buffer) {
+		m.string(keyValueKey, key)
+		m.message(keyValueValue, func(a *buffer) { a.anyValue(v) })
+	})
+}
+
+// anyValue encodes the fields of an AnyValue for v: strings, booleans,
+// numbers, byte slices and slices as such, and other values as formatted by
+// fmt.
+func (b *buffer) anyValue(v interface{}) {
+	switch v := v.(type) {
+	case string:
+		b.string(anyString, v)
+	case bool:
+		if v {
+			b.uint(anyBool, 1)
+		} else {
+			b.uint(anyBool, 0)
+		}
+	case []byte:
+		b.bytes(anyBytes, v)
+	case error:
+		b.string(anyString, v.Error())
+	case fmt.Stringer:
+		b.string(anyString, v.String())
+	case nil:
+		b.string(anyString, "<nil>")
+	default:
+		r := reflect.ValueOf(v)
+		switch r.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			b.uint(anyInt, uint64(r.Int()))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			b.uint(anyInt, r.Uint())
+		case reflect.Float32, reflect.Float64:
+			b.fixed64(anyDouble, math.Float64bits(r.Float()))
+		case reflect.Slice, reflect.Array:
+			b.message(anyArray, func(a *buffer) {
+				for i := 0; i < r.Len(); i++ {
+					elem := r.Index(i).Interface()
+					a.message(arrayValues, func(e *buffer) { e.anyValue(elem) })
+				}
+			})
+		default:
+			b.string(anyString, fmt.Sprintf("%+v", v))
+		}
+	}
+}
+
+// unixNano returns t as nanoseconds since the epoch, or 0 for the zero time.
+func unixNano(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+// trimNewline removes a trailing newline.
+func trimNewline(s string) string {
+	return strings.TrimSuffix(s, "\n")
+}
@@ -0,0 +1,51 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package recent serves the recent log lines that k8s.io/klog keeps in its
+// ring buffer, see klog.SetRingBuffer.
+//
+// Usage:
+//
+//	klog.SetRingBuffer(10000, 6)
+//	http.Handle("/debug/klog/recent", recent.Handler())
+//
+// The handler serves the lines as plain text in the output format, oldest
+// first. Like the lines of V calls that were not enabled, they may contain
+// details that the logs normally do not show, so the handler should only be
+// reachable by those who may read the logs.
+package recent
+
+import (
+	"io"
+	"net/http"
+
+	"k8s.io/klog"
+)
+
+// contentType is the content type of the served lines.
+const contentType = "text/plain; charset=utf-8"
+
+// Handler returns an http.Handler that serves the lines in the ring buffer.
+func Handler() http.Handler {
+	return handler{write: klog.WriteRecentLines}
+}
+
+type handler struct {
+	write func(w io.Writer) error
+}
+
+func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	h.write(w) // ignore error, the client went away
+}
@@ -0,0 +1,53 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package recent
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/klog"
+)
+
+func TestHandler(t *testing.T) {
+	flagset := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(flagset)
+	flagset.Set("logtostderr", "false")
+	flagset.Set("skip_headers", "true")
+	defer flagset.Set("skip_headers", "false")
+	defer flagset.Set("logtostderr", "true")
+	klog.SetOutput(ioutil.Discard)
+	defer klog.SetOutput(nil)
+	klog.SetRingBuffer(2, 4)
+	defer klog.SetRingBuffer(0, 0)
+
+	klog.Info("first")
+	klog.Warning("second")
+	klog.V(4).Info("third")
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/klog/recent", nil))
+	if got := rec.Header().Get("Content-Type"); got != contentType {
+		t.Errorf("got content type %q, expected %q", got, contentType)
+	}
+	if got, expected := rec.Body.String(), "second\nthird\n"; got != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, expected)
+	}
+	if lines := klog.RecentLines(); len(lines) != 2 || lines[0].Suppressed || !lines[1].Suppressed || lines[1].Level != 4 {
+		t.Errorf("unexpected lines %+v", lines)
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package syslog sends the lines of k8s.io/klog to a syslog server, in the
+// format of RFC 5424 or RFC 3164, over a unix socket, UDP or TCP.
+//
+// A Writer can be used as the output of the INFO severity, which klog writes
+// every line to, or be added as a sink to get the lines before they are
+// formatted:
+//
+//	w, err := syslog.Dial("udp", "loghost:514", syslog.Options{Facility: syslog.Daemon})
+//	...
+//	klog.SetOutputBySeverity("INFO", w)
+//
+// The severities are mapped to the priorities info, warning, err and crit.
+// As an output, a Writer only knows the severity of lines with a glog header;
+// with another -log_format or formatter, add it as a sink with klog.AddSink
+// instead.
+package syslog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// Format is the format of the messages.
+type Format int
+
+const (
+	// RFC5424 is the format of RFC 5424, as in
+	//
+	//	<14>1 2020-10-16T12:00:00.000000+02:00 host app 1234 - - file.go:12] message
+	RFC5424 Format = iota
+	// RFC3164 is the BSD format of RFC 3164, as in
+	//
+	//	<14>Oct 16 12:00:00 host app[1234]: file.go:12] message
+	RFC3164
+)
+
+// Facility is the syslog facility of the messages.
+type Facility int
+
+// The facilities of RFC 5424.
+const (
+	Kern Facility = iota
+	User
+	Mail
+	Daemon
+	Auth
+	Syslog
+	LPR
+	News
+	UUCP
+	Cron
+	AuthPriv
+	FTP
+	_
+	_
+	_
+	_
+	Local0
+	Local1
+	Local2
+	Local3
+	Local4
+	Local5
+	Local6
+	Local7
+)
+
+// The syslog severities that the klog severities map to.
+const (
+	severityCrit    = 2
+	severityErr     = 3
+	severityWarning = 4
+	severityInfo    = 6
+)
+
+// severities maps the first letter of a klog severity to a syslog severity.
+var severities = map[byte]int{'I': severityInfo, 'W': severityWarning, 'E': severityErr, 'F': severityCrit}
+
+// Options configures a Writer.
+type Options struct {
+	Format Format
+	// Facility is the facility of the messages. Kern, the zero value,
+	// is reserved for the kernel and replaced by User.
+	Facility Facility
+	// Tag is the APP-NAME or TAG of the messages, by default the name
+	// of the program.
+	Tag string
+	// Hostname is the HOSTNAME of the messages, by default the name of
+	// the host.
+	Hostname string
+	// Timeout limits connecting to the server and sending a message, so
+	// that a server that does not respond does not block logging. The
+	// default is DefaultTimeout.
+	Timeout time.Duration
+}
+
+// DefaultTimeout is the Timeout of Options that do not set one.
+const DefaultTimeout = 5 * time.Second
+
+// localSockets are the unix sockets that Dial tries for the local syslog
+// daemon.
+var localSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// A Writer sends log lines to a syslog server. It reconnects once when
+// sending fails.
+type Writer struct {
+	network, addr string
+	opts          Options
+	pid           int
+
+	mu   sync.Mutex // governs access to everything below
+	conn net.Conn
+	// stream is set if conn is a stream, whose messages need framing.
+	stream bool
+}
+
+// Dial connects to the syslog server at addr over network, which is "udp",
+// "tcp", "unix" or "unixgram". With an empty network and address, it
+// connects to the local syslog daemon.
+func Dial(network, addr string, opts Options) (*Writer, error) {
+	if opts.Facility == Kern {
+		opts.Facility = User
+	}
+	if opts.Tag == "" {
+		opts.Tag = filepath.Base(os.Args[0])
+	}
+	if opts.Hostname == "" {
+		opts.Hostname, _ = os.Hostname()
+		if opts.Hostname == "" {
+			opts.Hostname = "-"
+		}
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	w := &Writer{network: network, addr: addr, opts: opts, pid: os.Getpid()}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// connect connects to the server. w.mu is held.
+func (w *Writer) connect() error {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+	if w.network != "" || w.addr != "" {
+		conn, err := net.DialTimeout(w.network, w.addr, w.opts.Timeout)
+		if err != nil {
+			return err
+		}
+		w.conn, w.stream = conn, isStream(w.network)
+		return nil
+	}
+	for _, network := range []string{"unixgram", "unix"} {
+		for _, path := range localSockets {
+			if conn, err := net.DialTimeout(network, path, w.opts.Timeout); err == nil {
+				w.conn, w.stream = conn, isStream(network)
+				return nil
+			}
+		}
+	}
+	return errors.New("syslog: no local syslog daemon found")
+}
+
+// Write sends a line written by klog. The severity is taken from the glog
+// header, whose time stamp and thread ID are replaced by those of the syslog
+// format and the klog clock. Lines without a glog header, such as those of
+// -log_format=logfmt, are sent with the info priority; Log gets the severity
+// of every line.
+func (w *Writer) Write(p []byte) (int, error) {
+	severity, msg := severityInfo, bytes.TrimSuffix(p, []byte("\n"))
+	if len(msg) > 1 && msg[1] >= '0' && msg[1] <= '9' {
+		if s, ok := severities[msg[0]]; ok {
+			severity = s
+			if i := bytes.Index(msg, []byte("] ")); i >= 0 {
+				// Keep file:line.
+				start := bytes.LastIndexByte(msg[:i], ' ') + 1
+				msg = msg[start:]
+			}
+		}
+	}
+	if err := w.send(severity, klog.Now(), msg); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Log sends the line of a klog.Entry, which makes a Writer a klog.Sink.
+func (w *Writer) Log(e *klog.Entry) {
+	severity, ok := severities[e.Severity[0]]
+	if !ok {
+		severity = severityInfo
+	}
+	msg := fmt.Sprintf("%s:%d] %s", e.File, e.Line, e.Text())
+	w.send(severity, e.Time, bytes.TrimSuffix([]byte(msg), []byte("\n"))) // ignore error
+}
+
+// Close closes the connection.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// send sends a message, reconnecting once if that fails.
+func (w *Writer) send(severity int, t time.Time, msg []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn != nil {
+		if err := w.write(w.format(severity, t, msg)); err == nil {
+			return nil
+		}
+	}
+	if err := w.connect(); err != nil {
+		return err
+	}
+	return w.write(w.format(severity, t, msg))
+}
+
+// write writes a formatted message within the timeout. w.mu is held.
+func (w *Writer) write(b []byte) error {
+	w.conn.SetWriteDeadline(time.Now().Add(w.opts.Timeout)) // ignore err
+	_, err := w.conn.Write(b)
+	return err
+}
+
+// format returns a message in the format of w, framed for the connection.
+// w.mu is held.
+func (w *Writer) format(severity int, t time.Time, msg []byte) []byte {
+	var b bytes.Buffer
+	pri := int(w.opts.Facility)*8 + severity
+	switch w.opts.Format {
+	case RFC3164:
+		fmt.Fprintf(&b, "<%d>%s %s %s[%d]: %s", pri, t.Format(time.Stamp), w.opts.Hostname, w.opts.Tag, w.pid, msg)
+	default:
+		fmt.Fprintf(&b, "<%d>1 %s %s %s %d - - %s", pri, t.Format("2006-01-02T15:04:05.000000Z07:00"),
+			w.opts.Hostname, w.opts.Tag, w.pid, msg)
+	}
+	if !w.stream {
+		return b.Bytes()
+	}
+	if w.opts.Format == RFC3164 {
+		// Non-transparent framing.
+		return append(b.Bytes(), '\n')
+	}
+	// Octet counting, RFC 6587.
+	return append([]byte(strconv.Itoa(b.Len())+" "), b.Bytes()...)
+}
+
+// isStream reports whether network is a stream.
+func isStream(network string) bool {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		return true
+	}
+	return false
+}
+
+var _ klog.Sink = &Writer{}
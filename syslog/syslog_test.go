@@ -0,0 +1,159 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syslog
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// listenUDP returns a local UDP listener and a function that returns the
+// next message it receives.
+func listenUDP(t *testing.T) (net.PacketConn, func() string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return conn, func() string {
+		buf := make([]byte, 64*1024)
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return string(buf[:n])
+	}
+}
+
+func TestRFC5424(t *testing.T) {
+	conn, receive := listenUDP(t)
+	defer conn.Close()
+	w, err := Dial("udp", conn.LocalAddr().String(), Options{Hostname: "host", Tag: "app"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer w.Close()
+	klog.SetClock(klog.ClockFunc(func() time.Time { return time.Date(2020, 10, 16, 12, 0, 0, 123456000, time.UTC) }))
+	defer klog.SetClock(nil)
+
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"E1016 12:00:00.123456    1234 file.go:12] oops\n", `<11>1 2020-10-16T12:00:00.123456Z host app \d+ - - file.go:12\] oops`},
+		{"F1016 12:00:00.123456    1234 file.go:12] fatal\n", `<10>1 \S+ host app \d+ - - file.go:12\] fatal`},
+		{"no header\n", `<14>1 \S+ host app \d+ - - no header`},
+	}
+	for _, test := range tests {
+		if _, err := w.Write([]byte(test.line)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := receive(); !regexp.MustCompile("^" + test.expected + "$").MatchString(got) {
+			t.Errorf("got %q, expected %q", got, test.expected)
+		}
+	}
+}
+
+func TestRFC3164Output(t *testing.T) {
+	conn, receive := listenUDP(t)
+	defer conn.Close()
+	w, err := Dial("udp", conn.LocalAddr().String(), Options{Format: RFC3164, Facility: Daemon, Hostname: "host", Tag: "app"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer w.Close()
+
+	flagset := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(flagset)
+	flagset.Set("logtostderr", "false")
+	flagset.Set("stderrthreshold", "FATAL")
+	defer flagset.Set("logtostderr", "true")
+	defer flagset.Set("stderrthreshold", "ERROR")
+	klog.SetOutput(ioutil.Discard)
+	klog.SetOutputBySeverity("INFO", w)
+	defer klog.SetOutput(nil)
+
+	klog.Warning("careful")
+	_, _, line, _ := runtime.Caller(0)
+	expected := fmt.Sprintf(`<28>[A-Z][a-z]{2} [ \d]\d \d\d:\d\d:\d\d host app\[%d\]: syslog_test.go:%d\] careful`, os.Getpid(), line-1)
+	if got := receive(); !regexp.MustCompile("^" + expected + "$").MatchString(got) {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
+
+func TestTCPSink(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer l.Close()
+	w, err := Dial("tcp", l.Addr().String(), Options{Hostname: "host", Tag: "app"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer w.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+
+	w.Log(&klog.Entry{
+		Severity:      "ERROR",
+		Time:          time.Date(2020, 10, 16, 12, 0, 0, 123456000, time.UTC),
+		File:          "file.go",
+		Line:          12,
+		Message:       "Failed",
+		KeysAndValues: []interface{}{"pod", "kubedns"},
+		Structured:    true,
+	})
+	w.Log(&klog.Entry{Severity: "INFO", File: "file.go", Line: 13, Message: "second\n"})
+
+	// Octet counting framing.
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for _, expected := range []string{
+		fmt.Sprintf(`<11>1 2020-10-16T12:00:00.123456Z host app %d - - file.go:12] "Failed" pod="kubedns"`, os.Getpid()),
+		fmt.Sprintf(`<14>1 0001-01-01T00:00:00.000000Z host app %d - - file.go:13] second`, os.Getpid()),
+	} {
+		length, err := r.ReadString(' ')
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		n, err := strconv.Atoi(length[:len(length)-1])
+		if err != nil {
+			t.Fatalf("bad length %q", length)
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(msg) != expected {
+			t.Errorf("got %q, expected %q", msg, expected)
+		}
+	}
+}
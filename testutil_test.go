@@ -49,6 +49,14 @@ var severityName = []string{
 var global struct {
 	mu      sync.Mutex
 	outputs [numSeverity]*flushBuffer
+	// stderr receives what is written to standard error during a test.
+	stderr *os.File
+}
+
+// stderrContents returns what was written to standard error since testSetup.
+func stderrContents() string {
+	b, _ := ioutil.ReadFile(global.stderr.Name())
+	return string(b)
 }
 
 func setEnv(key, value string) func() {
@@ -79,6 +87,12 @@ func testSetup(t testing.TB, args ...string) (*flag.FlagSet, func()) {
 		tmpenv = "TMP"
 	}
 	unsetEnv := setEnv(tmpenv, tmpdir)
+	stderr := os.Stderr
+	global.stderr, err = ioutil.TempFile(tmpdir, "stderr")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	os.Stderr = global.stderr
 
 	args = append([]string{
 		"logtostderr", "false",
@@ -106,6 +120,8 @@ func testSetup(t testing.TB, args ...string) (*flag.FlagSet, func()) {
 		SetOutputBySeverity(severityName[i], global.outputs[i])
 	}
 
+	ResetCallSites()
+
 	for i := 0; i+1 < len(args); i += 2 {
 		if err := flagset.Set(args[i], args[i+1]); err != nil {
 			t.Fatalf("error setting %s=%q: %v", args[i], args[i+1], err)
@@ -125,6 +141,9 @@ func testSetup(t testing.TB, args ...string) (*flag.FlagSet, func()) {
 				t.Fatalf("error resetting %s=%q: %v", k, v, err)
 			}
 		}
+		SetClock(nil)
+		os.Stderr = stderr
+		global.stderr.Close()
 		unsetEnv()
 		os.RemoveAll(tmpdir)
 		global.mu.Unlock()